@@ -0,0 +1,244 @@
+package api_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/api"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/auth"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const adminAPIKey = "admin-key"
+
+// newAuthenticatedServer returns a server accepting adminAPIKey, requiring
+// credentials for reads too if requireReads is set.
+func newAuthenticatedServer(t *testing.T, requireReads bool) *api.Server {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(adminAPIKey))
+	authenticator, err := auth.New(context.Background(), config.Auth{
+		RequireReads: requireReads,
+		APIKeys:      []string{"admin:" + hex.EncodeToString(sum[:])},
+	})
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return api.NewServer(config.HTTPServer{}, store.NewMemoryMoviesStore(), prometheus.NewRegistry(), logger, authenticator, nil)
+}
+
+const policy = `
+roles:
+  admin: [movies:read, movies:write, movies:delete, movies:price]
+  editor: [movies:read, movies:write, movies:price]
+  clerk: [movies:read, movies:write]
+subjects:
+  api_key:admin: [admin]
+  api_key:editor: [editor]
+  api_key:clerk: [clerk]
+`
+
+// newAuthorizedServer returns a server accepting an API key named after each
+// role of policy, the key being the name too.
+func newAuthorizedServer(t *testing.T) *api.Server {
+	t.Helper()
+
+	return newAuthorizedServerWithStore(t, store.NewMemoryMoviesStore())
+}
+
+func newAuthorizedServerWithStore(t *testing.T, moviesStore store.Interface) *api.Server {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(policy), 0o600))
+
+	var apiKeys []string
+	for _, name := range []string{"admin", "editor", "clerk"} {
+		sum := sha256.Sum256([]byte(name))
+		apiKeys = append(apiKeys, name+":"+hex.EncodeToString(sum[:]))
+	}
+	authenticator, err := auth.New(context.Background(), config.Auth{APIKeys: apiKeys, PolicyFile: file})
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return api.NewServer(config.HTTPServer{}, moviesStore, prometheus.NewRegistry(), logger, authenticator, nil)
+}
+
+// staleReadStore reads movies in transactions as they were before their last
+// change, like a read racing a concurrent write.
+type staleReadStore struct {
+	store.Interface
+}
+
+func (s staleReadStore) WithTx(ctx context.Context, fn func(tx store.Interface) error) error {
+	return s.Interface.WithTx(ctx, func(tx store.Interface) error {
+		return fn(staleReadTx{tx})
+	})
+}
+
+type staleReadTx struct {
+	store.Interface
+}
+
+func (tx staleReadTx) GetByID(ctx context.Context, id uuid.UUID) (store.Movie, error) {
+	movie, err := tx.Interface.GetByID(ctx, id)
+	movie.Version--
+	return movie, err
+}
+
+func serve(server *api.Server, method string, path string, apiKey string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if apiKey != "" {
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+	}
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthentication(t *testing.T) {
+	movie := `{"id":"` + uuid.NewString() + `","title":"Auth","director":"Middleware","release_date":"2023-06-01T00:00:00Z","ticket_price":10}`
+
+	t.Run("should reject writes without credentials", func(t *testing.T) {
+		server := newAuthenticatedServer(t, false)
+
+		for _, w := range []*httptest.ResponseRecorder{
+			serve(server, http.MethodPost, "/api/movies", "", movie),
+			serve(server, http.MethodDelete, "/api/movies/"+uuid.NewString(), "", ""),
+			serve(server, http.MethodPost, "/api/movies:batch", "", `{"operations":[]}`),
+		} {
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, `Bearer realm="movies-api"`, w.Header().Get("WWW-Authenticate"))
+			assert.Contains(t, w.Body.String(), api.ProblemUnauthorized.Type)
+		}
+	})
+
+	t.Run("should accept writes with a valid API key", func(t *testing.T) {
+		server := newAuthenticatedServer(t, false)
+
+		w := serve(server, http.MethodPost, "/api/movies", adminAPIKey, movie)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should allow anonymous reads unless required", func(t *testing.T) {
+		w := serve(newAuthenticatedServer(t, false), http.MethodGet, "/api/movies", "", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = serve(newAuthenticatedServer(t, true), http.MethodGet, "/api/movies", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = serve(newAuthenticatedServer(t, true), http.MethodGet, "/api/movies", adminAPIKey, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should reject reads with invalid credentials", func(t *testing.T) {
+		w := serve(newAuthenticatedServer(t, false), http.MethodGet, "/api/movies", "wrong-key", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("should leave health and metrics open", func(t *testing.T) {
+		server := newAuthenticatedServer(t, true)
+
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodGet, "/health/live", "", "").Code)
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodGet, "/metrics", "", "").Code)
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	movie := func(id string, ticketPrice string) string {
+		return `{"id":"` + id + `","title":"Authz","director":"Policy","release_date":"2023-06-01T00:00:00Z","ticket_price":` + ticketPrice + `}`
+	}
+	patch := func(server *api.Server, id string, apiKey string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/movies/"+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should let editors change prices but not delete", func(t *testing.T) {
+		server := newAuthorizedServer(t)
+		id := uuid.NewString()
+		require.Equal(t, http.StatusOK, serve(server, http.MethodPost, "/api/movies", "editor", movie(id, "10")).Code)
+
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodPut, "/api/movies/"+id, "editor", movie(id, "12")).Code)
+		assert.Equal(t, http.StatusOK, patch(server, id, "editor", `{"ticket_price":14}`).Code)
+
+		w := serve(server, http.MethodDelete, "/api/movies/"+id, "editor", "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), api.ProblemForbidden.Type)
+		assert.Contains(t, w.Body.String(), auth.ScopeDelete)
+
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodDelete, "/api/movies/"+id, "admin", "").Code)
+	})
+
+	t.Run("should only let clerks update movies keeping their price", func(t *testing.T) {
+		server := newAuthorizedServer(t)
+		id := uuid.NewString()
+		require.Equal(t, http.StatusOK, serve(server, http.MethodPost, "/api/movies", "clerk", movie(id, "10")).Code)
+
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodPut, "/api/movies/"+id, "clerk", movie(id, "10")).Code)
+		assert.Equal(t, http.StatusOK, patch(server, id, "clerk", `{"title":"Renamed"}`).Code)
+
+		w := serve(server, http.MethodPut, "/api/movies/"+id, "clerk", movie(id, "12"))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), auth.ScopePrice)
+		assert.Equal(t, http.StatusForbidden, patch(server, id, "clerk", `{"ticket_price":12}`).Code)
+
+		w = serve(server, http.MethodGet, "/api/movies/"+id, "clerk", "")
+		assert.Contains(t, w.Body.String(), `"ticket_price":10`)
+	})
+
+	t.Run("should fail price checked writes if the movie changed since it was read", func(t *testing.T) {
+		server := newAuthorizedServerWithStore(t, staleReadStore{store.NewMemoryMoviesStore()})
+		id := uuid.NewString()
+		require.Equal(t, http.StatusOK, serve(server, http.MethodPost, "/api/movies", "clerk", movie(id, "10")).Code)
+		require.Equal(t, http.StatusOK, serve(server, http.MethodPut, "/api/movies/"+id, "editor", movie(id, "10")).Code)
+
+		assert.Equal(t, http.StatusPreconditionFailed, serve(server, http.MethodPut, "/api/movies/"+id, "clerk", movie(id, "10")).Code)
+		assert.Equal(t, http.StatusPreconditionFailed, patch(server, id, "clerk", `{"title":"Renamed","ticket_price":10}`).Code)
+	})
+
+	t.Run("should check every operation of a batch", func(t *testing.T) {
+		server := newAuthorizedServer(t)
+		id := uuid.NewString()
+		require.Equal(t, http.StatusOK, serve(server, http.MethodPost, "/api/movies", "admin", movie(id, "10")).Code)
+
+		w := serve(server, http.MethodPost, "/api/movies:batch", "editor", `{"operations":[{"op":"update","id":"`+id+`","movie":`+movie(id, "12")+`},{"op":"delete","id":"`+id+`"}]}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "operations[1]")
+
+		w = serve(server, http.MethodPost, "/api/movies:batch", "clerk", `{"operations":[{"op":"update","id":"`+id+`","movie":`+movie(id, "10")+`}]}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = serve(server, http.MethodPost, "/api/movies:batch", "editor", `{"operations":[{"op":"update","id":"`+id+`","movie":`+movie(id, "12")+`}]}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should let anonymous callers read only", func(t *testing.T) {
+		server := newAuthorizedServer(t)
+
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodGet, "/api/movies", "", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(server, http.MethodPost, "/api/movies", "", movie(uuid.NewString(), "10")).Code)
+	})
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/auth"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxBatchOperations keeps a batch within HTTP_SERVER_BATCH_TIMEOUT, 10s
+	// by default, raise both together
+	maxBatchOperations = 1000
+
+	batchModeAtomic     = "atomic"
+	batchModeBestEffort = "best_effort"
+)
+
+type batchOperationRequest struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id"`
+	Version int64           `json:"version"`
+	Movie   json.RawMessage `json:"movie"`
+}
+
+// batchRequest is the body of POST /api/movies:batch. An atomic batch, the
+// default, applies every operation or none of them while a best effort batch
+// applies each operation independently.
+type batchRequest struct {
+	Mode       string                  `json:"mode"`
+	Operations []batchOperationRequest `json:"operations"`
+
+	operations []store.BatchOperation
+}
+
+func (br *batchRequest) Bind(r *http.Request) error {
+	v := &validator{}
+
+	if br.Mode == "" {
+		br.Mode = batchModeAtomic
+	}
+	v.check(br.Mode == batchModeAtomic || br.Mode == batchModeBestEffort, "mode", fmt.Sprintf("must be %s or %s", batchModeAtomic, batchModeBestEffort))
+	v.check(len(br.Operations) > 0, "operations", "must not be empty")
+	v.check(len(br.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must have at most %d operations", maxBatchOperations))
+	if len(br.Operations) > maxBatchOperations {
+		return v.err()
+	}
+
+	for i, op := range br.Operations {
+		operation, err := op.bind(r)
+		v.merge(fmt.Sprintf("operations[%d].", i), err)
+		br.operations = append(br.operations, operation)
+	}
+
+	return v.err()
+}
+
+// bind validates the operation and converts it to a store.BatchOperation, the
+// movie is validated the same way as the body of the matching endpoint.
+func (op batchOperationRequest) bind(r *http.Request) (store.BatchOperation, error) {
+	v := &validator{}
+	operation := store.BatchOperation{Type: store.BatchOperationType(op.Op)}
+
+	var id uuid.UUID
+	if op.Op == string(store.BatchUpdate) || op.Op == string(store.BatchDelete) {
+		var err error
+		id, err = uuid.Parse(op.ID)
+		v.check(err == nil, "id", "must be a valid UUID")
+	}
+
+	switch operation.Type {
+	case store.BatchCreate:
+		data := &CreateMovieRequest{}
+		if v.decode(op.Movie, data, "movie", "must be a movie") {
+			v.merge("movie.", data.Bind(r))
+		}
+		operation.Create = store.CreateMovieParams{
+			ID:          data.id,
+			Title:       data.Title,
+			Director:    data.Director,
+			ReleaseDate: data.ReleaseDate,
+			TicketPrice: data.TicketPrice,
+		}
+	case store.BatchUpdate:
+		data := &updateMovieRequest{}
+		if v.decode(op.Movie, data, "movie", "must be a movie") {
+			v.merge("movie.", data.Bind(r))
+		}
+		operation.ID = id
+		operation.Update = store.UpdateMovieParams{
+			Title:           data.Title,
+			Director:        data.Director,
+			ReleaseDate:     data.ReleaseDate,
+			TicketPrice:     data.TicketPrice,
+			ExpectedVersion: op.Version,
+		}
+	case store.BatchDelete:
+		operation.ID = id
+		operation.Delete = store.DeleteMovieParams{ExpectedVersion: op.Version}
+	default:
+		v.check(false, "op", fmt.Sprintf("must be %s, %s or %s", store.BatchCreate, store.BatchUpdate, store.BatchDelete))
+	}
+	v.check(op.Version >= 0, "version", "must not be negative")
+
+	return operation, v.err()
+}
+
+type batchResultResponse struct {
+	ID     uuid.UUID `json:"id"`
+	Status int       `json:"status"`
+	Error  *Problem  `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResultResponse `json:"results"`
+}
+
+func (br batchResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// handleBatchMovies applies a batch of writes and reports the outcome of each
+// operation in order, a failed operation carries the problem the matching
+// endpoint would have returned.
+func (s *Server) handleBatchMovies(w http.ResponseWriter, r *http.Request) {
+	data := &batchRequest{}
+	if err := render.Bind(r, data); err != nil {
+		renderBindError(w, r, err)
+		return
+	}
+	// a batch takes a token per operation, batching writes must not get
+	// around the rate limit of the route
+	if !s.takeRate(w, r, len(data.operations)) {
+		return
+	}
+
+	for i, operation := range data.operations {
+		// an update replaces the ticket price, unlike PUT the current price is
+		// not compared so updates in a batch always need the price scope
+		scope := auth.ScopeWrite
+		switch operation.Type {
+		case store.BatchUpdate:
+			scope = auth.ScopePrice
+		case store.BatchDelete:
+			scope = auth.ScopeDelete
+		}
+		if !s.authorized(r, scope) {
+			renderError(w, r, ProblemForbidden.New(fmt.Errorf("operations[%d]: %s scope required", i, scope)))
+			return
+		}
+	}
+
+	results, err := s.store.Batch(r.Context(), data.operations, data.Mode == batchModeAtomic)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	response := batchResponse{Results: make([]batchResultResponse, 0, len(results))}
+	for i, err := range results {
+		result := batchResultResponse{ID: data.operations[i].ID, Status: http.StatusOK}
+		if data.operations[i].Type == store.BatchCreate {
+			result.ID = data.operations[i].Create.ID
+		}
+		if err != nil {
+			result.Error = problemFromError(err)
+			result.Status = result.Error.Status
+			logProblem(r, result.Error)
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	render.Render(w, r, response)
+}
@@ -0,0 +1,149 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/api/apitest"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resultStatuses(results []client.BatchResult) []int {
+	var statuses []int
+	for _, r := range results {
+		statuses = append(statuses, r.Status)
+	}
+	return statuses
+}
+
+func TestBatchMovies(t *testing.T) {
+	h := apitest.New(t)
+	update := client.UpdateMovieRequest{
+		Title:       "Batch Updated",
+		Director:    "Apitest",
+		ReleaseDate: newCreateMovieRequest("").ReleaseDate,
+		TicketPrice: 15,
+	}
+
+	t.Run("given valid batch, should apply every operation", func(t *testing.T) {
+		toUpdate := createMovie(t, h, newCreateMovieRequest("Batch"))
+		toDelete := createMovie(t, h, newCreateMovieRequest("Batch"))
+		create := newCreateMovieRequest("Batch Created")
+		update := update
+		update.Version = toUpdate.Version
+
+		results, err := h.Client.BatchMovies(context.Background(), client.BatchRequest{
+			Operations: []client.BatchOperation{
+				client.CreateOperation(create),
+				client.UpdateOperation(toUpdate.ID, update),
+				client.DeleteOperation(toDelete.ID, 0),
+			},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, resultStatuses(results))
+		assert.Equal(t, []uuid.UUID{uuid.MustParse(create.ID), toUpdate.ID, toDelete.ID}, []uuid.UUID{results[0].ID, results[1].ID, results[2].ID})
+
+		created, err := h.Client.GetMovie(context.Background(), results[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Batch Created", created.Title)
+		updated, err := h.Client.GetMovie(context.Background(), toUpdate.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Batch Updated", updated.Title)
+		_, err = h.Client.GetMovie(context.Background(), toDelete.ID)
+		requireProblem(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given atomic batch fails, should roll back and report each operation", func(t *testing.T) {
+		existing := createMovie(t, h, newCreateMovieRequest("Batch"))
+		create := newCreateMovieRequest("Batch")
+		duplicate := newCreateMovieRequest("Batch")
+		duplicate.ID = existing.ID.String()
+
+		results, err := h.Client.BatchMovies(context.Background(), client.BatchRequest{
+			Operations: []client.BatchOperation{
+				client.CreateOperation(create),
+				client.CreateOperation(duplicate),
+				client.DeleteOperation(existing.ID, 0),
+			},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []int{http.StatusFailedDependency, http.StatusConflict, http.StatusFailedDependency}, resultStatuses(results))
+		assert.Equal(t, "/problems/conflict", results[1].Error.Type)
+
+		_, err = h.Client.GetMovie(context.Background(), uuid.MustParse(create.ID))
+		requireProblem(t, err, http.StatusNotFound)
+		_, err = h.Client.GetMovie(context.Background(), existing.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("given best effort batch fails, should apply the other operations", func(t *testing.T) {
+		existing := createMovie(t, h, newCreateMovieRequest("Batch"))
+		create := newCreateMovieRequest("Batch")
+		stale := update
+		stale.Version = existing.Version + 1
+
+		results, err := h.Client.BatchMovies(context.Background(), client.BatchRequest{
+			BestEffort: true,
+			Operations: []client.BatchOperation{
+				client.DeleteOperation(uuid.New(), 0),
+				client.CreateOperation(create),
+				client.UpdateOperation(existing.ID, stale),
+			},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []int{http.StatusNotFound, http.StatusOK, http.StatusPreconditionFailed}, resultStatuses(results))
+
+		_, err = h.Client.GetMovie(context.Background(), uuid.MustParse(create.ID))
+		assert.NoError(t, err)
+	})
+
+	t.Run("given invalid operations, should return field errors and apply none", func(t *testing.T) {
+		create := newCreateMovieRequest("Batch")
+		invalid := newCreateMovieRequest(" ")
+
+		_, err := h.Client.BatchMovies(context.Background(), client.BatchRequest{
+			Operations: []client.BatchOperation{
+				client.CreateOperation(create),
+				client.CreateOperation(invalid),
+				{Op: "upsert"},
+				{Op: "delete", ID: "invalid"},
+				{Op: "update", ID: uuid.NewString()},
+			},
+		})
+
+		problem := requireProblem(t, err, http.StatusUnprocessableEntity)
+		var fields []string
+		for _, fe := range problem.Errors {
+			fields = append(fields, fe.Field)
+		}
+		assert.Equal(t, []string{"operations[1].movie.title", "operations[2].op", "operations[3].id", "operations[4].movie"}, fields)
+
+		_, err = h.Client.GetMovie(context.Background(), uuid.MustParse(create.ID))
+		requireProblem(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given too many operations, should return field error", func(t *testing.T) {
+		operations := make([]string, 1001)
+		for i := range operations {
+			operations[i] = fmt.Sprintf(`{"op":"delete","id":%q}`, uuid.NewString())
+		}
+
+		resp := doRequest(t, h, http.MethodPost, "/api/movies:batch", `{"operations":[`+strings.Join(operations, ",")+`]}`)
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+		var problem client.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		require.Len(t, problem.Errors, 1)
+		assert.Equal(t, "operations", problem.Errors[0].Field)
+	})
+}
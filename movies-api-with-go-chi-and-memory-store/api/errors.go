@@ -1,36 +1,134 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 
-	"github.com/go-chi/render"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
-type ErrResponse struct {
-	Err            error `json:"-"` // low-level runtime error
-	HTTPStatusCode int   `json:"-"` // http response status code
+const problemContentType = "application/problem+json"
 
-	StatusText string `json:"status"`          // user-level status message
-	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
-	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
-}
+// statusClientClosedRequest is the non-standard status nginx logs for requests
+// whose client disconnected before the response.
+const statusClientClosedRequest = 499
 
-func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
-	render.Status(r, e.HTTPStatusCode)
-	return nil
+// ProblemType is an entry in the catalogue of errors returned by the API as
+// RFC 7807 problem details.
+type ProblemType struct {
+	Type   string
+	Title  string
+	Status int
 }
 
 var (
-	ErrNotFound            = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
-	ErrBadRequest          = &ErrResponse{HTTPStatusCode: 400, StatusText: "Bad request"}
-	ErrInternalServerError = &ErrResponse{HTTPStatusCode: 500, StatusText: "Internal Server Error"}
+	ProblemBadRequest          = ProblemType{Type: "/problems/bad-request", Title: "Bad Request", Status: http.StatusBadRequest}
+	ProblemUnauthorized        = ProblemType{Type: "/problems/unauthorized", Title: "Unauthorized", Status: http.StatusUnauthorized}
+	ProblemForbidden           = ProblemType{Type: "/problems/forbidden", Title: "Forbidden", Status: http.StatusForbidden}
+	ProblemNotFound            = ProblemType{Type: "/problems/not-found", Title: "Resource Not Found", Status: http.StatusNotFound}
+	ProblemConflict            = ProblemType{Type: "/problems/conflict", Title: "Conflict", Status: http.StatusConflict}
+	ProblemUnsupportedMedia    = ProblemType{Type: "/problems/unsupported-media-type", Title: "Unsupported Media Type", Status: http.StatusUnsupportedMediaType}
+	ProblemPreconditionFailed  = ProblemType{Type: "/problems/precondition-failed", Title: "Precondition Failed", Status: http.StatusPreconditionFailed}
+	ProblemValidation          = ProblemType{Type: "/problems/validation", Title: "Validation Failed", Status: http.StatusUnprocessableEntity}
+	ProblemTooManyRequests     = ProblemType{Type: "/problems/too-many-requests", Title: "Too Many Requests", Status: http.StatusTooManyRequests}
+	ProblemFailedDependency    = ProblemType{Type: "/problems/failed-dependency", Title: "Failed Dependency", Status: http.StatusFailedDependency}
+	ProblemInternalServerError = ProblemType{Type: "/problems/internal-server-error", Title: "Internal Server Error", Status: http.StatusInternalServerError}
+	ProblemTimeout             = ProblemType{Type: "/problems/timeout", Title: "Timeout", Status: http.StatusGatewayTimeout}
+	// ProblemClientClosedRequest is only seen in logs and metrics, the client
+	// is gone by the time it is written
+	ProblemClientClosedRequest = ProblemType{Type: "/problems/client-closed-request", Title: "Client Closed Request", Status: statusClientClosedRequest}
 )
 
-func ErrConflict(err error) render.Renderer {
-	return &ErrResponse{
-		Err:            err,
-		HTTPStatusCode: 409,
-		StatusText:     "Duplicate Key",
-		ErrorText:      err.Error(),
+// New returns a problem of this type caused by err, the error message is only
+// exposed as detail for client errors.
+func (t ProblemType) New(err error) *Problem {
+	p := &Problem{
+		Err:    err,
+		Type:   t.Type,
+		Title:  t.Title,
+		Status: t.Status,
+	}
+	if err != nil && t.Status < http.StatusInternalServerError {
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+type Problem struct {
+	Err error `json:"-"` // low-level runtime error
+
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"` // field-level validation errors
+}
+
+func (p *Problem) Error() string {
+	if p.Err != nil {
+		return p.Title + ": " + p.Err.Error()
 	}
+	return p.Title
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err
+}
+
+// problemFromError translates errors returned by handlers and stores to the
+// problem type they are reported as, anything unknown is an internal error.
+func problemFromError(err error) *Problem {
+	var (
+		problem            *Problem
+		validationErr      *ValidationError
+		storeValidationErr *store.ValidationError
+		notFoundErr        *store.RecordNotFoundError
+		duplicateKeyErr    *store.DuplicateKeyError
+		versionMismatchErr *store.VersionMismatchError
+		batchAbortedErr    *store.BatchAbortedError
+	)
+
+	switch {
+	case errors.As(err, &problem):
+		return problem
+	case errors.As(err, &validationErr):
+		p := ProblemValidation.New(err)
+		p.Errors = validationErr.Errors
+		return p
+	case errors.As(err, &storeValidationErr):
+		p := ProblemValidation.New(err)
+		p.Errors = []FieldError{{Field: storeValidationErr.Field, Message: storeValidationErr.Message}}
+		return p
+	case errors.As(err, &notFoundErr):
+		return ProblemNotFound.New(err)
+	case errors.As(err, &duplicateKeyErr):
+		return ProblemConflict.New(err)
+	case errors.As(err, &versionMismatchErr):
+		return ProblemPreconditionFailed.New(err)
+	case errors.As(err, &batchAbortedErr):
+		return ProblemFailedDependency.New(err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return ProblemTimeout.New(err)
+	case errors.Is(err, context.Canceled):
+		return ProblemClientClosedRequest.New(err)
+	default:
+		return ProblemInternalServerError.New(err)
+	}
+}
+
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := problemFromError(err)
+	problem.Instance = r.URL.Path
+	problem.RequestID = middleware.GetReqID(r.Context())
+	logProblem(r, problem)
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
 }
@@ -0,0 +1,44 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/api/apitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	h := apitest.New(t)
+
+	doRequest(t, h, http.MethodGet, "/api/movies/"+uuid.NewString(), "")
+	doRequest(t, h, http.MethodGet, "/api/movies/"+uuid.NewString(), "")
+	doRequest(t, h, http.MethodGet, "/unknown", "")
+
+	resp := doRequest(t, h, http.MethodGet, "/metrics", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	t.Run("should count requests by route pattern and status", func(t *testing.T) {
+		assert.Contains(t, string(body), `http_requests_total{method="GET",route="/api/movies/{id}",status="404"} 2`)
+		assert.Contains(t, string(body), `http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	})
+
+	t.Run("should observe request latency", func(t *testing.T) {
+		assert.Contains(t, string(body), `http_request_duration_seconds_count{method="GET",route="/api/movies/{id}",status="404"} 2`)
+	})
+
+	t.Run("should report in flight requests", func(t *testing.T) {
+		assert.Contains(t, string(body), "http_requests_in_flight 1")
+	})
+
+	t.Run("should record store calls", func(t *testing.T) {
+		assert.Contains(t, string(body), `store_call_duration_seconds_count{method="GetByID"} 2`)
+		assert.Contains(t, string(body), `store_call_errors_total{error="not_found",method="GetByID"} 2`)
+	})
+}
@@ -0,0 +1,162 @@
+// Package auth authenticates the callers of the API with static API keys or
+// JWT bearer tokens.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/config"
+)
+
+const (
+	APIKeyHeader = "X-API-Key"
+
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+var (
+	// ErrNoCredentials is returned for a request without an API key or a
+	// bearer token.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned for an unknown API key or a token that
+	// failed verification.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject is the name of the API key or the sub claim of the token
+	Subject string
+	// Method is MethodAPIKey or MethodJWT
+	Method string
+	// Claims are the claims of the token, nil for an API key
+	Claims jwt.MapClaims
+	// Scopes are the sorted scopes granted to the principal
+	Scopes []string
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	i := sort.SearchStrings(p.Scopes, scope)
+	return i < len(p.Scopes) && p.Scopes[i] == scope
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying principal.
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal in ctx, if the request was authenticated.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// Authenticator verifies the credentials of requests against the API keys and
+// token keys it was configured with, and grants scopes by its policy.
+type Authenticator struct {
+	apiKeys      apiKeys
+	jwt          *jwtVerifier
+	requireReads bool
+	// policy is nil if every principal is granted AllScopes
+	policy *Policy
+}
+
+// New returns an authenticator for config, the API keys file, JWKS and policy
+// are read once here so a broken configuration fails at startup.
+func New(ctx context.Context, config config.Auth) (*Authenticator, error) {
+	apiKeys, err := loadAPIKeys(config.APIKeys, config.APIKeysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy *Policy
+	if config.PolicyFile != "" {
+		policy, err = LoadPolicy(config.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	verifier, err := newJWTVerifier(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(apiKeys) == 0 && verifier == nil {
+		return nil, fmt.Errorf("AUTH_ENABLED requires AUTH_API_KEYS, AUTH_API_KEYS_FILE, AUTH_JWT_SECRET, AUTH_JWKS_FILE or AUTH_JWKS_URL")
+	}
+
+	return &Authenticator{
+		apiKeys:      apiKeys,
+		jwt:          verifier,
+		requireReads: config.RequireReads,
+		policy:       policy,
+	}, nil
+}
+
+// RequireReads reports whether reads need credentials too.
+func (a *Authenticator) RequireReads() bool {
+	return a.requireReads
+}
+
+// Authenticate returns the principal identified by the X-API-Key header or
+// the bearer token of r with the scopes it is granted. The error wraps
+// ErrNoCredentials if r has neither and ErrInvalidCredentials if they do not
+// verify.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	principal, err := a.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.policy == nil {
+		principal.Scopes = AllScopes
+	} else {
+		principal.Scopes = a.policy.scopes(principal)
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		name, ok := a.apiKeys.lookup(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown API key", ErrInvalidCredentials)
+		}
+		return &Principal{Subject: name, Method: MethodAPIKey}, nil
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return nil, ErrNoCredentials
+	}
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, fmt.Errorf("%w: Authorization must be a Bearer token", ErrInvalidCredentials)
+	}
+	if a.jwt == nil {
+		return nil, fmt.Errorf("%w: bearer tokens are not accepted", ErrInvalidCredentials)
+	}
+
+	claims, err := a.jwt.verify(r.Context(), token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	// the subject names the principal in policies and rate limit buckets,
+	// tokens without one would all share them
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: token has no sub claim", ErrInvalidCredentials)
+	}
+	return &Principal{Subject: subject, Method: MethodJWT, Claims: claims}, nil
+}
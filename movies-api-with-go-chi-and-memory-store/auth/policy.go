@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ScopeRead   = "movies:read"
+	ScopeWrite  = "movies:write"
+	ScopeDelete = "movies:delete"
+	// ScopePrice allows changing the ticket price of an existing movie
+	ScopePrice = "movies:price"
+)
+
+// AllScopes are granted to every principal when no policy is configured, they
+// are sorted like the scopes of a Principal.
+var AllScopes = []string{ScopeDelete, ScopePrice, ScopeRead, ScopeWrite}
+
+// Policy grants scopes to principals through roles, e.g.
+//
+//	roles:
+//	  viewer: [movies:read]
+//	  editor: [movies:read, movies:write, movies:price]
+//	subjects:
+//	  api_key:ci: [editor]
+//	  jwt:alice: [viewer]
+//
+// Subjects are namespaced by authentication method, api_key:<name> for API
+// keys and jwt:<sub> for tokens, so a token cannot take the roles of an API key
+// by claiming its name. A principal has the roles its subject is listed with
+// and those of the roles claim of its token, and the scopes of the scope claim
+// of its token.
+type Policy struct {
+	Roles    map[string][]string `yaml:"roles" json:"roles"`
+	Subjects map[string][]string `yaml:"subjects" json:"subjects"`
+}
+
+// LoadPolicy reads the policy in file, JSON being valid YAML either format can
+// be used. Unknown scopes and roles are rejected.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &policy, nil
+}
+
+func (p *Policy) validate() error {
+	for role, scopes := range p.Roles {
+		for _, scope := range scopes {
+			if !isScope(scope) {
+				return fmt.Errorf("role %s: unknown scope %q, must be one of %s", role, scope, strings.Join(AllScopes, ", "))
+			}
+		}
+	}
+	for subject, roles := range p.Subjects {
+		method, _, _ := strings.Cut(subject, ":")
+		if method != MethodAPIKey && method != MethodJWT {
+			return fmt.Errorf("subject %s: must be %s:<name> or %s:<sub>", subject, MethodAPIKey, MethodJWT)
+		}
+		for _, role := range roles {
+			if _, ok := p.Roles[role]; !ok {
+				return fmt.Errorf("subject %s: unknown role %q", subject, role)
+			}
+		}
+	}
+	return nil
+}
+
+func isScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// scopes returns the sorted scopes granted to principal.
+func (p *Policy) scopes(principal *Principal) []string {
+	roles := append([]string(nil), p.Subjects[principal.Method+":"+principal.Subject]...)
+	granted := map[string]bool{}
+
+	if principal.Claims != nil {
+		roles = append(roles, claimStrings(principal.Claims["roles"])...)
+		for _, scope := range claimStrings(principal.Claims["scope"]) {
+			if isScope(scope) {
+				granted[scope] = true
+			}
+		}
+	}
+	for _, role := range roles {
+		for _, scope := range p.Roles[role] {
+			granted[scope] = true
+		}
+	}
+
+	scopes := make([]string, 0, len(granted))
+	for scope := range granted {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// claimStrings returns the values of a claim that is either a space separated
+// string, like the OAuth scope claim, or an array of strings.
+func claimStrings(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -2,21 +2,84 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/api"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/auth"
 	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/logging"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/ratelimit"
 	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 func main() {
 	ctx := context.Background()
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("config.Load failed", slog.Any("error", err))
+		os.Exit(1)
 	}
 
-	store := store.NewMemoryMoviesStore()
-	server := api.NewServer(cfg.HTTPServer, store)
+	logger, err := logging.New(os.Stderr, cfg.Logging)
+	if err != nil {
+		slog.Error("logging.New failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	moviesStore, err := store.Open(ctx, cfg.MemoryStore)
+	if err != nil {
+		logger.Error("store.Open failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer moviesStore.Close()
+
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	tracerProvider, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		logger.Error("tracing.Setup failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			logger.Error("tracerProvider.Shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	var authenticator *auth.Authenticator
+	if cfg.Auth.Enabled {
+		authenticator, err = auth.New(ctx, cfg.Auth)
+		if err != nil {
+			logger.Error("auth.New failed", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit.Enabled {
+		limiter, err = ratelimit.New(cfg.RateLimit)
+		if err != nil {
+			logger.Error("ratelimit.New failed", slog.Any("error", err))
+			os.Exit(1)
+		}
+		if len(cfg.RateLimit.TrustedProxies) == 0 {
+			logger.Warn("RATE_LIMIT_TRUSTED_PROXIES is not set, clients behind a proxy share the rate limit of its IP address")
+		}
+	}
+
+	instrumentedStore := store.NewInstrumentedStore(moviesStore, cfg.MemoryStore.Driver, metrics)
+	server := api.NewServer(cfg.HTTPServer, store.NewTracedStore(instrumentedStore, cfg.MemoryStore.Driver), metrics, logger, authenticator, limiter)
 	server.Start(ctx)
 }
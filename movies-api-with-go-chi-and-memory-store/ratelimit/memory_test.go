@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	sut := NewMemoryLimiter()
+	sut.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 4}
+
+	takeN := func(key string, cost int) Result {
+		result, err := sut.Take(context.Background(), key, limit, cost)
+		require.NoError(t, err)
+		return result
+	}
+	take := func(key string) Result {
+		return takeN(key, 1)
+	}
+
+	t.Run("should refill the bucket at the rate", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			require.True(t, take("client").Allowed)
+		}
+		result := take("client")
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+		assert.Equal(t, 2*time.Second, result.Reset)
+
+		now = now.Add(time.Second)
+		result = take("client")
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 1, result.Remaining)
+	})
+
+	t.Run("should take the cost of the request", func(t *testing.T) {
+		result := takeN("batch", 3)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 1, result.Remaining)
+
+		result = takeN("batch", 2)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	})
+
+	t.Run("should allow a cost over the burst from a full bucket and owe the rest", func(t *testing.T) {
+		result := takeN("large-batch", 10)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 0, result.Remaining)
+
+		now = now.Add(2 * time.Second)
+		result = take("large-batch")
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 1500*time.Millisecond, result.RetryAfter)
+	})
+
+	t.Run("should drop full buckets", func(t *testing.T) {
+		take("idle")
+		now = now.Add(sweepInterval + time.Second)
+		take("active")
+
+		assert.NotContains(t, sut.buckets, "idle")
+		assert.NotContains(t, sut.buckets, "client")
+		assert.Contains(t, sut.buckets, "active")
+	})
+}
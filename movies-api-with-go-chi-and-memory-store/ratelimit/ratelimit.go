@@ -0,0 +1,211 @@
+// Package ratelimit limits the rate of requests of each client with token
+// buckets kept by a pluggable backend.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/config"
+)
+
+// Limit is a token bucket holding up to Burst tokens, refilled at Rate tokens
+// per second. Every request takes a token, a batch one per operation.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the state of a bucket after a request tried to take its tokens.
+type Result struct {
+	Allowed bool
+	// Limit is the burst of the bucket and Remaining the whole tokens left
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until a request is allowed, zero if it was
+	RetryAfter time.Duration
+}
+
+// Backend keeps the token buckets by key, Take must be safe for concurrent use.
+// A request costing more tokens than the burst is allowed from a full bucket
+// and leaves it in debt, otherwise it could never be allowed.
+type Backend interface {
+	Take(ctx context.Context, key string, limit Limit, cost int) (Result, error)
+}
+
+var backends = map[string]func(config config.RateLimit) (Backend, error){}
+
+// Register makes a backend available to New by RATE_LIMIT_BACKEND name, it is
+// meant to be called from init and panics if the name is already registered.
+func Register(name string, open func(config config.RateLimit) (Backend, error)) {
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("ratelimit: Register called twice for backend %s", name))
+	}
+	backends[name] = open
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Limiter takes a token for each request of a client to a route from the
+// bucket of the pair.
+type Limiter struct {
+	backend        Backend
+	limit          Limit
+	routes         map[string]Limit
+	ipLimit        *Limit
+	trustedProxies []netip.Prefix
+}
+
+// New returns a limiter for config using the backend named by config.Backend.
+func New(config config.RateLimit) (*Limiter, error) {
+	open, ok := backends[config.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown RATE_LIMIT_BACKEND %q, registered backends are %s", config.Backend, strings.Join(Backends(), ", "))
+	}
+
+	l := &Limiter{
+		limit:  Limit{Rate: config.Rate, Burst: config.Burst},
+		routes: map[string]Limit{},
+	}
+	if err := l.limit.validate(); err != nil {
+		return nil, fmt.Errorf("RATE_LIMIT_RATE and RATE_LIMIT_BURST: %w", err)
+	}
+
+	if config.IPRate != 0 {
+		l.ipLimit = &Limit{Rate: config.IPRate, Burst: config.IPBurst}
+		if err := l.ipLimit.validate(); err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_IP_RATE and RATE_LIMIT_IP_BURST: %w", err)
+		}
+	}
+
+	for _, route := range config.Routes {
+		name, limit, err := parseRoute(route)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_ROUTES %q: %w", route, err)
+		}
+		l.routes[name] = limit
+	}
+
+	for _, cidr := range config.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_TRUSTED_PROXIES: %w", err)
+		}
+		l.trustedProxies = append(l.trustedProxies, prefix.Masked())
+	}
+
+	backend, err := open(config)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit backend %s: %w", config.Backend, err)
+	}
+	l.backend = backend
+	return l, nil
+}
+
+// parseRoute parses a "METHOD pattern=rate:burst" override, the pattern may
+// contain = and : itself so it is split at the last =.
+func parseRoute(route string) (string, Limit, error) {
+	i := strings.LastIndex(route, "=")
+	if i < 0 {
+		return "", Limit{}, fmt.Errorf("must be METHOD pattern=rate:burst")
+	}
+	name, value := strings.TrimSpace(route[:i]), route[i+1:]
+	if method, pattern, ok := strings.Cut(name, " "); !ok || method == "" || !strings.HasPrefix(pattern, "/") {
+		return "", Limit{}, fmt.Errorf("must be METHOD pattern=rate:burst")
+	}
+
+	rate, burst, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", Limit{}, fmt.Errorf("limit must be rate:burst")
+	}
+	var (
+		limit Limit
+		err   error
+	)
+	if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil {
+		return "", Limit{}, fmt.Errorf("invalid rate: %w", err)
+	}
+	if limit.Burst, err = strconv.Atoi(burst); err != nil {
+		return "", Limit{}, fmt.Errorf("invalid burst: %w", err)
+	}
+	return name, limit, limit.validate()
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 || l.Burst < 1 {
+		return fmt.Errorf("rate must be positive and burst at least 1")
+	}
+	return nil
+}
+
+// Take takes cost tokens from the bucket of client for route, route being the
+// method and pattern, e.g. "GET /api/movies/{id}".
+func (l *Limiter) Take(ctx context.Context, route string, client string, cost int) (Result, error) {
+	limit, ok := l.routes[route]
+	if !ok {
+		limit = l.limit
+	}
+	return l.backend.Take(ctx, route+" "+client, limit, cost)
+}
+
+// TakeIP takes a token from the bucket of ip shared by all routes, every
+// request is allowed if the IP limit is off.
+func (l *Limiter) TakeIP(ctx context.Context, ip string) (Result, error) {
+	if l.ipLimit == nil {
+		return Result{Allowed: true}, nil
+	}
+	return l.backend.Take(ctx, "ip "+ip, *l.ipLimit, 1)
+}
+
+// ClientIP returns the IP address of the client of r. If the request came
+// through trusted proxies the address they appended to X-Forwarded-For last
+// is used, the rest of the header can be forged by the client.
+func (l *Limiter) ClientIP(r *http.Request) string {
+	ip, err := parseRemoteAddr(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && l.trusted(ip); i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		ip = addr.Unmap()
+	}
+	return ip.String()
+}
+
+func parseRemoteAddr(remoteAddr string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(remoteAddr)
+	return addr.Unmap(), err
+}
+
+func (l *Limiter) trusted(ip netip.Addr) bool {
+	for _, prefix := range l.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package store_test
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMoviesStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Interface {
+		return store.NewMemoryMoviesStore()
+	})
+}
+
+func TestDurableMemoryMoviesStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Interface {
+		sut, err := store.OpenMemoryMoviesStore(config.MemoryStore{
+			DataDir:          t.TempDir(),
+			SnapshotInterval: 10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { sut.Close() })
+		return sut
+	})
+}
+
+func newMovie(t *testing.T, sut store.Interface) store.CreateMovieParams {
+	p := store.CreateMovieParams{
+		ID:          uuid.New(),
+		Title:       "Durable",
+		Director:    "Memory Store",
+		ReleaseDate: time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 12.5,
+	}
+	require.NoError(t, sut.Create(context.Background(), p))
+	return p
+}
+
+func openMemoryMoviesStore(t *testing.T, dir string) *store.MemoryMoviesStore {
+	sut, err := store.OpenMemoryMoviesStore(config.MemoryStore{DataDir: dir})
+	require.NoError(t, err)
+	t.Cleanup(func() { sut.Close() })
+	return sut
+}
+
+func TestOpenMemoryMoviesStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("given changes, should replay them when reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		sut := openMemoryMoviesStore(t, dir)
+		created := newMovie(t, sut)
+		updated := newMovie(t, sut)
+		deleted := newMovie(t, sut)
+		require.NoError(t, sut.Update(ctx, updated.ID, store.UpdateMovieParams{Title: "Updated", Director: "Memory Store", ReleaseDate: updated.ReleaseDate, TicketPrice: 10}))
+		require.NoError(t, sut.Delete(ctx, deleted.ID, store.DeleteMovieParams{}))
+		require.NoError(t, sut.Snapshot())
+		title := "Patched"
+		require.NoError(t, sut.Patch(ctx, created.ID, store.PatchMovieParams{Title: &title}))
+		require.NoError(t, sut.WithTx(ctx, func(tx store.Interface) error {
+			return tx.Delete(ctx, updated.ID, store.DeleteMovieParams{})
+		}))
+		expected, err := sut.GetAll(ctx)
+		require.NoError(t, err)
+
+		reopened := openMemoryMoviesStore(t, dir)
+
+		actual, err := reopened.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+		movies, err := reopened.Search(ctx, store.SearchMoviesParams{Query: "patched"})
+		require.NoError(t, err)
+		assert.Len(t, movies, 1)
+	})
+
+	t.Run("given rolled back transaction, should not replay its changes", func(t *testing.T) {
+		dir := t.TempDir()
+		sut := openMemoryMoviesStore(t, dir)
+		existing := newMovie(t, sut)
+		_ = sut.WithTx(ctx, func(tx store.Interface) error {
+			require.NoError(t, tx.Delete(ctx, existing.ID, store.DeleteMovieParams{}))
+			return assert.AnError
+		})
+
+		reopened := openMemoryMoviesStore(t, dir)
+
+		_, err := reopened.GetByID(ctx, existing.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("given torn write at end of log, should drop it", func(t *testing.T) {
+		dir := t.TempDir()
+		sut := openMemoryMoviesStore(t, dir)
+		existing := newMovie(t, sut)
+		torn := newMovie(t, sut)
+		walPath := filepath.Join(dir, "movies.wal")
+		wal, err := os.ReadFile(walPath)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(walPath, wal[:len(wal)-5], 0o644))
+
+		reopened := openMemoryMoviesStore(t, dir)
+
+		_, err = reopened.GetByID(ctx, existing.ID)
+		assert.NoError(t, err)
+		_, err = reopened.GetByID(ctx, torn.ID)
+		var notFoundErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &notFoundErr)
+
+		appended := newMovie(t, reopened)
+		reopened = openMemoryMoviesStore(t, dir)
+		_, err = reopened.GetByID(ctx, appended.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("given corrupt frame before end of log, should fail to open", func(t *testing.T) {
+		dir := t.TempDir()
+		sut := openMemoryMoviesStore(t, dir)
+		newMovie(t, sut)
+		newMovie(t, sut)
+		walPath := filepath.Join(dir, "movies.wal")
+		wal, err := os.ReadFile(walPath)
+		require.NoError(t, err)
+		wal[10] ^= 0xff
+		require.NoError(t, os.WriteFile(walPath, wal, 0o644))
+
+		_, err = store.OpenMemoryMoviesStore(config.MemoryStore{DataDir: dir})
+
+		assert.ErrorContains(t, err, "checksum mismatch")
+	})
+
+	t.Run("given implausible frame length before end of log, should fail to open", func(t *testing.T) {
+		dir := t.TempDir()
+		sut := openMemoryMoviesStore(t, dir)
+		newMovie(t, sut)
+		newMovie(t, sut)
+		walPath := filepath.Join(dir, "movies.wal")
+		wal, err := os.ReadFile(walPath)
+		require.NoError(t, err)
+		binary.LittleEndian.PutUint32(wal[0:4], uint32(len(wal)))
+		require.NoError(t, os.WriteFile(walPath, wal, 0o644))
+
+		_, err = store.OpenMemoryMoviesStore(config.MemoryStore{DataDir: dir})
+
+		assert.ErrorContains(t, err, "invalid frame length")
+	})
+
+	t.Run("given zero filled space at end of log, should drop it", func(t *testing.T) {
+		dir := t.TempDir()
+		sut := openMemoryMoviesStore(t, dir)
+		existing := newMovie(t, sut)
+		walPath := filepath.Join(dir, "movies.wal")
+		wal, err := os.ReadFile(walPath)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(walPath, append(wal, make([]byte, 64)...), 0o644))
+
+		reopened := openMemoryMoviesStore(t, dir)
+
+		_, err = reopened.GetByID(ctx, existing.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("given closed store, should reopen from snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		sut := openMemoryMoviesStore(t, dir)
+		existing := newMovie(t, sut)
+		require.NoError(t, sut.Close())
+
+		wal, err := os.Stat(filepath.Join(dir, "movies.wal"))
+		require.NoError(t, err)
+		assert.Zero(t, wal.Size())
+
+		reopened := openMemoryMoviesStore(t, dir)
+		_, err = reopened.GetByID(ctx, existing.ID)
+		assert.NoError(t, err)
+	})
+}
+
+func TestMemoryMoviesStoreWithTx(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("given deletes and creates in tx, should read them within tx", func(t *testing.T) {
+		sut := store.NewMemoryMoviesStore()
+		deleted := store.CreateMovieParams{ID: uuid.New(), Title: "Deleted Transaction", Director: "Memory Store", TicketPrice: 10}
+		require.NoError(t, sut.Create(ctx, deleted))
+		created := store.CreateMovieParams{ID: uuid.New(), Title: "Created Transaction", Director: "Memory Store", TicketPrice: 10}
+
+		err := sut.WithTx(ctx, func(tx store.Interface) error {
+			require.NoError(t, tx.Delete(ctx, deleted.ID, store.DeleteMovieParams{}))
+			require.NoError(t, tx.Create(ctx, created))
+
+			page, err := tx.List(ctx, store.ListMoviesParams{})
+			require.NoError(t, err)
+			assert.Equal(t, []uuid.UUID{created.ID}, movieIDs(page.Movies))
+			movies, err := tx.Search(ctx, store.SearchMoviesParams{Query: "transaction"})
+			require.NoError(t, err)
+			assert.Equal(t, []uuid.UUID{created.ID}, movieIDs(movies))
+			return nil
+		})
+		require.NoError(t, err)
+
+		movies, err := sut.Search(ctx, store.SearchMoviesParams{Query: "transaction"})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{created.ID}, movieIDs(movies))
+	})
+
+	t.Run("given movie deleted and created again in tx, should commit the new movie", func(t *testing.T) {
+		sut := store.NewMemoryMoviesStore()
+		p := store.CreateMovieParams{ID: uuid.New(), Title: "Original", Director: "Memory Store", TicketPrice: 10}
+		require.NoError(t, sut.Create(ctx, p))
+
+		err := sut.WithTx(ctx, func(tx store.Interface) error {
+			require.NoError(t, tx.Delete(ctx, p.ID, store.DeleteMovieParams{}))
+			p.Title = "Recreated"
+			return tx.Create(ctx, p)
+		})
+		require.NoError(t, err)
+
+		m, err := sut.GetByID(ctx, p.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Recreated", m.Title)
+		movies, err := sut.Search(ctx, store.SearchMoviesParams{Query: "original"})
+		require.NoError(t, err)
+		assert.Empty(t, movies)
+	})
+}
+
+func TestMemoryMoviesStoreSearch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("given tokens around the prefix, should match only tokens starting with it", func(t *testing.T) {
+		sut := store.NewMemoryMoviesStore()
+		ids := map[string]uuid.UUID{}
+		for _, title := range []string{"Tram", "Trainspotting", "Train", "Trap"} {
+			ids[title] = uuid.New()
+			require.NoError(t, sut.Create(ctx, store.CreateMovieParams{ID: ids[title], Title: title, Director: "Memory Store", TicketPrice: 10}))
+		}
+
+		movies, err := sut.Search(ctx, store.SearchMoviesParams{Query: "train"})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{ids["Train"], ids["Trainspotting"]}, movieIDs(movies))
+
+		require.NoError(t, sut.Delete(ctx, ids["Train"], store.DeleteMovieParams{}))
+		err = sut.WithTx(ctx, func(tx store.Interface) error {
+			require.NoError(t, tx.Create(ctx, store.CreateMovieParams{ID: uuid.New(), Title: "Trail", Director: "Memory Store", TicketPrice: 10}))
+			movies, err := tx.Search(ctx, store.SearchMoviesParams{Query: "trai"})
+			require.NoError(t, err)
+			assert.Len(t, movies, 2)
+			return nil
+		})
+		require.NoError(t, err)
+
+		movies, err = sut.Search(ctx, store.SearchMoviesParams{Query: "train"})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{ids["Trainspotting"]}, movieIDs(movies))
+	})
+}
+
+func movieIDs(movies []store.Movie) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, m := range movies {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
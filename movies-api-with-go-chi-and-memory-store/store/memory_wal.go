@@ -0,0 +1,283 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// The write-ahead log and the snapshot are sequences of frames, each frame is
+// a little endian uint32 payload length and CRC-32C checksum followed by the
+// JSON payload. A frame of the log holds the changes of one write, a snapshot
+// is a single frame holding every movie.
+const (
+	walFileName      = "movies.wal"
+	snapshotFileName = "movies.snapshot"
+	frameHeaderSize  = 8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornFrame is returned for a frame cut short by a crash while it was being
+// appended, it is the end of the log rather than a corruption.
+var errTornFrame = errors.New("torn frame")
+
+// memoryChange is a change to the movies of a MemoryMoviesStore, a put carries
+// the whole movie so replaying a change twice has no further effect.
+type memoryChange struct {
+	Put    *Movie     `json:"put,omitempty"`
+	Delete *uuid.UUID `json:"delete,omitempty"`
+}
+
+type memoryWAL struct {
+	dir  string
+	file *os.File
+	size int64
+	// sync flushes every append to disk before the write returns
+	sync bool
+	// err fails every append once a failed append could not be rolled back
+	err error
+}
+
+// openMemoryWAL replays the snapshot and the log in dir through apply and
+// opens the log for appending. A torn frame at the end of the log is cut off,
+// any other invalid frame fails the open.
+func openMemoryWAL(dir string, sync bool, apply func(changes []memoryChange)) (*memoryWAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	snapshot, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(snapshot) > 0 {
+		var movies []Movie
+		if _, err := readFrame(snapshot, &movies); err != nil {
+			return nil, fmt.Errorf("read %s: %w", snapshotFileName, err)
+		}
+		changes := make([]memoryChange, 0, len(movies))
+		for i := range movies {
+			changes = append(changes, memoryChange{Put: &movies[i]})
+		}
+		apply(changes)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	offset := 0
+	for offset < len(data) {
+		var changes []memoryChange
+		n, err := readFrame(data[offset:], &changes)
+		if errors.Is(err, errTornFrame) {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("read %s at offset %d: %w", walFileName, offset, err)
+		}
+		apply(changes)
+		offset += n
+	}
+
+	if offset < len(data) {
+		if err := file.Truncate(int64(offset)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &memoryWAL{
+		dir:  dir,
+		file: file,
+		size: int64(offset),
+		sync: sync,
+	}, nil
+}
+
+// append writes changes to the log as a single frame, so they are replayed
+// all together or not at all.
+func (w *memoryWAL) append(changes []memoryChange) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	frame, err := newFrame(changes)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.file.Write(frame); err != nil {
+		return w.rollback(err)
+	}
+	if w.sync {
+		if err := w.file.Sync(); err != nil {
+			return w.rollback(err)
+		}
+	}
+	w.size += int64(len(frame))
+	return nil
+}
+
+// rollback cuts the log back to its size before a failed append, the caller
+// does not apply the changes so they must not be replayed either. When that
+// fails too the log no longer matches the store and every later append fails.
+func (w *memoryWAL) rollback(err error) error {
+	if truncateErr := w.file.Truncate(w.size); truncateErr != nil {
+		w.err = fmt.Errorf("append to %s failed and could not be rolled back: %w", walFileName, errors.Join(err, truncateErr))
+		return w.err
+	}
+	return err
+}
+
+// snapshot replaces the snapshot with movies and empties the log. A crash
+// before the log is emptied replays it over the new snapshot, which leaves
+// the movies as they are.
+func (w *memoryWAL) snapshot(movies map[uuid.UUID]Movie) error {
+	if w.size == 0 {
+		return nil
+	}
+
+	sorted := make([]Movie, 0, len(movies))
+	for _, m := range movies {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareMovies(sorted[i], sorted[j], SortByCreatedAt) < 0
+	})
+
+	frame, err := newFrame(sorted)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(w.dir, snapshotFileName)
+	if err := writeFileSync(path+".tmp", frame); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		return err
+	}
+
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.file.Sync()
+}
+
+func (w *memoryWAL) close() error {
+	return w.file.Close()
+}
+
+func newFrame(v any) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	return append(frame, payload...), nil
+}
+
+// readFrame decodes the frame at the start of b into v and returns its size.
+// A frame running past the end of b, or the last frame of b failing its
+// checksum, is torn; zero filled space left by a crash is torn as well. A
+// frame with a bad length followed by a valid frame is a corruption instead.
+func readFrame(b []byte, v any) (int, error) {
+	if len(b) < frameHeaderSize {
+		return 0, errTornFrame
+	}
+
+	length := int(binary.LittleEndian.Uint32(b[0:4]))
+	checksum := binary.LittleEndian.Uint32(b[4:8])
+	size := frameHeaderSize + length
+	if length == 0 || size > len(b) {
+		if containsFrame(b[1:]) {
+			return 0, fmt.Errorf("invalid frame length %d", length)
+		}
+		return 0, errTornFrame
+	}
+
+	payload := b[frameHeaderSize:size]
+	if crc32.Checksum(payload, crcTable) != checksum {
+		if size == len(b) {
+			return 0, errTornFrame
+		}
+		return 0, errors.New("checksum mismatch")
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// containsFrame reports whether a valid frame starts anywhere in b, which a
+// torn frame at the end of the log cannot be followed by.
+func containsFrame(b []byte) bool {
+	for i := 0; i+frameHeaderSize < len(b); i++ {
+		length := int(binary.LittleEndian.Uint32(b[i : i+4]))
+		size := frameHeaderSize + length
+		if length == 0 || size > len(b)-i {
+			continue
+		}
+		if crc32.Checksum(b[i+frameHeaderSize:i+size], crcTable) == binary.LittleEndian.Uint32(b[i+4:i+8]) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir flushes the directory entry of a renamed file to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
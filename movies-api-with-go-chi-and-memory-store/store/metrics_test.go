@@ -0,0 +1,58 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	sut := store.NewInstrumentedStore(store.NewMemoryMoviesStore(), "memory", reg)
+	p := store.CreateMovieParams{
+		ID:          uuid.New(),
+		Title:       "Instrumented",
+		Director:    "Store",
+		ReleaseDate: time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 10,
+	}
+
+	require.NoError(t, sut.Create(ctx, p))
+	var duplicateKeyErr *store.DuplicateKeyError
+	require.ErrorAs(t, sut.Create(ctx, p), &duplicateKeyErr)
+	_, err := sut.GetByID(ctx, uuid.New())
+	var notFoundErr *store.RecordNotFoundError
+	require.ErrorAs(t, err, &notFoundErr)
+	errTx := errors.New("tx failed")
+	require.ErrorIs(t, sut.WithTx(ctx, func(tx store.Interface) error {
+		require.NoError(t, tx.Delete(ctx, p.ID, store.DeleteMovieParams{}))
+		return errTx
+	}), errTx)
+
+	t.Run("should observe latency of every call", func(t *testing.T) {
+		count, err := testutil.GatherAndCount(reg, "store_call_duration_seconds")
+		require.NoError(t, err)
+		assert.Equal(t, 4, count)
+	})
+
+	t.Run("should count errors by method and kind", func(t *testing.T) {
+		expected := `
+# HELP store_call_errors_total Number of failed store calls by method and error.
+# TYPE store_call_errors_total counter
+store_call_errors_total{error="duplicate_key",method="Create"} 1
+store_call_errors_total{error="not_found",method="GetByID"} 1
+store_call_errors_total{error="other",method="WithTx"} 1
+`
+		assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "store_call_errors_total"))
+	})
+}
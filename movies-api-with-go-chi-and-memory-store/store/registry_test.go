@@ -0,0 +1,36 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	t.Run("given memory driver, should open memory store", func(t *testing.T) {
+		sut, err := store.Open(context.Background(), config.MemoryStore{Driver: store.MemoryDriver})
+
+		require.NoError(t, err)
+		defer sut.Close()
+		movies, err := sut.GetAll(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, movies)
+	})
+
+	t.Run("given unknown driver, should list registered drivers", func(t *testing.T) {
+		_, err := store.Open(context.Background(), config.MemoryStore{Driver: "oracle"})
+
+		assert.EqualError(t, err, `unknown STORE_DRIVER "oracle", registered drivers are memory`)
+	})
+
+	t.Run("given negative snapshot interval, should return error", func(t *testing.T) {
+		_, err := store.Open(context.Background(), config.MemoryStore{Driver: store.MemoryDriver, SnapshotInterval: -time.Second})
+
+		assert.EqualError(t, err, "store driver memory: MEMORY_STORE_SNAPSHOT_INTERVAL must not be negative")
+	})
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+
+// dbSystems maps STORE_DRIVER names to the db.system attribute of their spans.
+var dbSystems = map[string]attribute.KeyValue{
+	"postgres":  semconv.DBSystemPostgreSQL,
+	"mysql":     semconv.DBSystemMySQL,
+	"sqlserver": semconv.DBSystemMSSQL,
+	"sqlite":    semconv.DBSystemSqlite,
+	"mongo":     semconv.DBSystemMongoDB,
+}
+
+// sqlOperations and mongoOperations name the statement each method runs, the
+// db.operation attribute of its span.
+var (
+	sqlOperations = map[string]string{
+		"GetAll":     "SELECT",
+		"List":       "SELECT",
+		"Search":     "SELECT",
+		"GetByID":    "SELECT",
+		"Create":     "INSERT",
+		"CreateMany": "INSERT",
+		"Update":     "UPDATE",
+		"Patch":      "UPDATE",
+		"Delete":     "DELETE",
+	}
+	mongoOperations = map[string]string{
+		"GetAll":     "find",
+		"List":       "find",
+		"Search":     "find",
+		"GetByID":    "findOne",
+		"Create":     "insertOne",
+		"CreateMany": "insertMany",
+		"Update":     "updateOne",
+		"Patch":      "updateOne",
+		"Delete":     "deleteOne",
+	}
+)
+
+// TracedStore starts a client span for every call to the store it wraps, as a
+// child of the span in the context of the call.
+type TracedStore struct {
+	next       Interface
+	attributes []attribute.KeyValue
+	operations map[string]string
+	// tx is the span of the transaction a copy passed to WithTx is bound to
+	tx trace.Span
+}
+
+// NewTracedStore wraps s, the store opened for the STORE_DRIVER name, and
+// describes its spans with the db attributes of that backend.
+func NewTracedStore(s Interface, name string) *TracedStore {
+	t := &TracedStore{next: s}
+	if system, ok := dbSystems[name]; ok {
+		t.attributes = append(t.attributes, system)
+		if system == semconv.DBSystemMongoDB {
+			t.attributes = append(t.attributes, semconv.DBMongoDBCollection("movies"))
+			t.operations = mongoOperations
+		} else {
+			t.attributes = append(t.attributes, semconv.DBSQLTable("movies"))
+			t.operations = sqlOperations
+		}
+	} else {
+		t.attributes = append(t.attributes, semconv.DBSystemKey.String(name))
+	}
+	return t
+}
+
+func (s *TracedStore) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	if s.tx != nil {
+		ctx = trace.ContextWithSpan(ctx, s.tx)
+	}
+	attributes := s.attributes
+	if operation, ok := s.operations[method]; ok {
+		attributes = append(attributes[:len(attributes):len(attributes)], semconv.DBOperation(operation))
+	}
+	return otel.Tracer(tracerName).Start(ctx, "store."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// recordStatement sets the db.statement attribute of the store span in ctx.
+// Statements keep their placeholders, so no values end up in traces, and a
+// call running several statements is described by the last.
+func recordStatement(ctx context.Context, statement string) {
+	trace.SpanFromContext(ctx).SetAttributes(semconv.DBStatement(statement))
+}
+
+// endSpan ends span, recording err. Errors the API maps to a client error, like a
+// missing movie, are expected and leave the span status unset.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		kind := errorLabel(err)
+		span.SetAttributes(attribute.String("store.error", kind))
+		if kind == "other" || kind == "canceled" {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+func (s *TracedStore) GetAll(ctx context.Context) ([]Movie, error) {
+	ctx, span := s.start(ctx, "GetAll")
+	movies, err := s.next.GetAll(ctx)
+	endSpan(span, err)
+	return movies, err
+}
+
+func (s *TracedStore) List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error) {
+	ctx, span := s.start(ctx, "List")
+	page, err := s.next.List(ctx, listMoviesParams)
+	endSpan(span, err)
+	return page, err
+}
+
+func (s *TracedStore) Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error) {
+	ctx, span := s.start(ctx, "Search")
+	movies, err := s.next.Search(ctx, searchMoviesParams)
+	endSpan(span, err)
+	return movies, err
+}
+
+func (s *TracedStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
+	ctx, span := s.start(ctx, "GetByID")
+	movie, err := s.next.GetByID(ctx, id)
+	endSpan(span, err)
+	return movie, err
+}
+
+func (s *TracedStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
+	ctx, span := s.start(ctx, "Create")
+	err := s.next.Create(ctx, createMovieParams)
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracedStore) CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error {
+	ctx, span := s.start(ctx, "CreateMany")
+	span.SetAttributes(attribute.Int("store.movies", len(createMoviesParams)))
+	err := s.next.CreateMany(ctx, createMoviesParams)
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracedStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
+	ctx, span := s.start(ctx, "Update")
+	err := s.next.Update(ctx, id, updateMovieParams)
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracedStore) Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error {
+	ctx, span := s.start(ctx, "Patch")
+	err := s.next.Patch(ctx, id, patchMovieParams)
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracedStore) Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error {
+	ctx, span := s.start(ctx, "Delete")
+	err := s.next.Delete(ctx, id, deleteMovieParams)
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracedStore) Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error) {
+	ctx, span := s.start(ctx, "Batch")
+	span.SetAttributes(attribute.Int("store.operations", len(operations)), attribute.Bool("store.atomic", atomic))
+	results, err := s.next.Batch(ctx, operations, atomic)
+	endSpan(span, err)
+	return results, err
+}
+
+// WithTx traces the transaction as a span, the calls made through tx are its
+// children.
+func (s *TracedStore) WithTx(ctx context.Context, fn func(tx Interface) error) error {
+	ctx, span := s.start(ctx, "WithTx")
+	err := s.next.WithTx(ctx, func(tx Interface) error {
+		return fn(&TracedStore{next: tx, attributes: s.attributes, operations: s.operations, tx: span})
+	})
+	endSpan(span, err)
+	return err
+}
+
+func (s *TracedStore) Ping(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Ping")
+	err := s.next.Ping(ctx)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,78 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-memory-store/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracedStore(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previousProvider) })
+
+	ctx := context.Background()
+	sut := store.NewTracedStore(store.NewMemoryMoviesStore(), "postgres")
+	p := store.CreateMovieParams{
+		ID:          uuid.New(),
+		Title:       "Traced",
+		Director:    "Store",
+		ReleaseDate: time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 10,
+	}
+
+	require.NoError(t, sut.Create(ctx, p))
+	_, err := sut.GetByID(ctx, uuid.New())
+	var notFoundErr *store.RecordNotFoundError
+	require.ErrorAs(t, err, &notFoundErr)
+	errTx := errors.New("tx failed")
+	require.ErrorIs(t, sut.WithTx(ctx, func(tx store.Interface) error {
+		require.NoError(t, tx.Delete(ctx, p.ID, store.DeleteMovieParams{}))
+		return errTx
+	}), errTx)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	create, getByID, deleteInTx, withTx := spans[0], spans[1], spans[2], spans[3]
+
+	t.Run("should describe calls with the db attributes of the backend", func(t *testing.T) {
+		assert.Equal(t, "store.Create", create.Name())
+		assert.Equal(t, trace.SpanKindClient, create.SpanKind())
+		assert.Contains(t, create.Attributes(), semconv.DBSystemPostgreSQL)
+		assert.Contains(t, create.Attributes(), semconv.DBSQLTable("movies"))
+		assert.Contains(t, create.Attributes(), semconv.DBOperation("INSERT"))
+		assert.Contains(t, getByID.Attributes(), semconv.DBOperation("SELECT"))
+	})
+
+	t.Run("should leave the status of expected errors unset", func(t *testing.T) {
+		assert.Contains(t, getByID.Attributes(), attribute.String("store.error", "not_found"))
+		assert.Equal(t, codes.Unset, getByID.Status().Code)
+	})
+
+	t.Run("should record failures", func(t *testing.T) {
+		assert.Equal(t, "store.WithTx", withTx.Name())
+		assert.Equal(t, codes.Error, withTx.Status().Code)
+		assert.Equal(t, errTx.Error(), withTx.Status().Description)
+		require.Len(t, withTx.Events(), 1)
+		assert.Equal(t, "exception", withTx.Events()[0].Name)
+	})
+
+	t.Run("should trace calls through the transaction as its children", func(t *testing.T) {
+		assert.Equal(t, "store.Delete", deleteInTx.Name())
+		assert.Equal(t, withTx.SpanContext().SpanID(), deleteInTx.Parent().SpanID())
+	})
+}
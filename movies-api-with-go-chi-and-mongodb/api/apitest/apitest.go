@@ -0,0 +1,51 @@
+// Package apitest runs an api.Server on an httptest.Server so the API can be
+// tested over HTTP with the typed client.
+package apitest
+
+import (
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/api"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/client"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+type Harness struct {
+	Store   store.Interface
+	Metrics *prometheus.Registry
+	Server  *httptest.Server
+	Client  *client.Client
+}
+
+// New starts a server backed by a new MemoryMoviesStore, without
+// authentication. The server is closed when the test completes.
+func New(t *testing.T) *Harness {
+	t.Helper()
+
+	return NewWithStore(t, store.MemoryDriver, store.NewMemoryMoviesStore())
+}
+
+// NewWithStore starts a server backed by s like New, the store calls are
+// labelled with driver, the STORE_DRIVER name of s, in metrics and spans.
+func NewWithStore(t *testing.T, driver string, s store.Interface) *Harness {
+	t.Helper()
+
+	metrics := prometheus.NewRegistry()
+	instrumented := store.NewInstrumentedStore(s, driver, metrics)
+	server := httptest.NewServer(api.NewServer(config.HTTPServer{}, store.NewTracedStore(instrumented, driver), metrics, discardLogger, nil, nil))
+	t.Cleanup(server.Close)
+
+	return &Harness{
+		Store:   s,
+		Metrics: metrics,
+		Server:  server,
+		Client:  client.New(server.URL, server.Client()),
+	}
+}
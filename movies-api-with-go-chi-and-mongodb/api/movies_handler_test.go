@@ -0,0 +1,680 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/api/apitest"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCreateMovieRequest(title string) client.CreateMovieRequest {
+	return client.CreateMovieRequest{
+		ID:          uuid.NewString(),
+		Title:       title,
+		Director:    "Apitest",
+		ReleaseDate: time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 12.5,
+	}
+}
+
+func createMovie(t *testing.T, h *apitest.Harness, request client.CreateMovieRequest) client.Movie {
+	t.Helper()
+
+	id, err := h.Client.CreateMovie(context.Background(), request)
+	require.NoError(t, err)
+
+	movie, err := h.Client.GetMovie(context.Background(), id)
+	require.NoError(t, err)
+
+	return movie
+}
+
+func doRequest(t *testing.T, h *apitest.Harness, method string, path string, body string) *http.Response {
+	t.Helper()
+
+	contentType := "application/json"
+	if method == http.MethodPatch {
+		contentType = "application/merge-patch+json"
+	}
+	return doRequestWithContentType(t, h, method, path, contentType, body)
+}
+
+func doRequestWithContentType(t *testing.T, h *apitest.Harness, method string, path string, contentType string, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, h.Server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	if body != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := h.Server.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func requireProblem(t *testing.T, err error, status int) *client.Problem {
+	t.Helper()
+
+	var problem *client.Problem
+	require.ErrorAs(t, err, &problem)
+	require.Equal(t, status, problem.Status, "unexpected problem: %v", problem)
+
+	return problem
+}
+
+func movieIDs(movies []client.Movie) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, m := range movies {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestRoutes(t *testing.T) {
+	h := apitest.New(t)
+	movie := createMovie(t, h, newCreateMovieRequest("Routes"))
+	existing := "/api/movies/" + movie.ID.String()
+	missing := "/api/movies/" + uuid.NewString()
+	valid := `{"title":"Routes","director":"Apitest","release_date":"2001-01-01T00:00:00Z","ticket_price":12.5}`
+	duplicate := fmt.Sprintf(`{"id":%q,"title":"Routes","director":"Apitest","release_date":"2001-01-01T00:00:00Z","ticket_price":12.5}`, movie.ID)
+	invalid := `{"title":"","director":"Apitest","release_date":"2001-01-01T00:00:00Z","ticket_price":-1}`
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"health", http.MethodGet, "/health", "", http.StatusOK},
+		{"list", http.MethodGet, "/api/movies", "", http.StatusOK},
+		{"list with invalid limit", http.MethodGet, "/api/movies?limit=0", "", http.StatusBadRequest},
+		{"list with unsupported sort", http.MethodGet, "/api/movies?sort=director", "", http.StatusBadRequest},
+		{"list with invalid cursor", http.MethodGet, "/api/movies?cursor=invalid", "", http.StatusBadRequest},
+		{"list with invalid release date", http.MethodGet, "/api/movies?release_date_from=2001", "", http.StatusBadRequest},
+		{"list with invalid ticket price", http.MethodGet, "/api/movies?min_ticket_price=free", "", http.StatusBadRequest},
+		{"search", http.MethodGet, "/api/movies/search?q=routes", "", http.StatusOK},
+		{"search without q", http.MethodGet, "/api/movies/search", "", http.StatusBadRequest},
+		{"search with invalid limit", http.MethodGet, "/api/movies/search?q=routes&limit=51", "", http.StatusBadRequest},
+		{"search with too many words", http.MethodGet, "/api/movies/search?q=" + strings.Repeat("a-", 11), "", http.StatusBadRequest},
+		{"create", http.MethodPost, "/api/movies", valid, http.StatusOK},
+		{"create with duplicate id", http.MethodPost, "/api/movies", duplicate, http.StatusConflict},
+		{"create with malformed json", http.MethodPost, "/api/movies", `{"title":`, http.StatusBadRequest},
+		{"create with invalid fields", http.MethodPost, "/api/movies", invalid, http.StatusUnprocessableEntity},
+		{"batch", http.MethodPost, "/api/movies:batch", `{"operations":[{"op":"create","movie":` + valid + `}]}`, http.StatusOK},
+		{"batch with malformed json", http.MethodPost, "/api/movies:batch", `{"operations":`, http.StatusBadRequest},
+		{"batch with invalid mode", http.MethodPost, "/api/movies:batch", `{"mode":"eventually","operations":[{"op":"create","movie":` + valid + `}]}`, http.StatusUnprocessableEntity},
+		{"get", http.MethodGet, existing, "", http.StatusOK},
+		{"get missing", http.MethodGet, missing, "", http.StatusNotFound},
+		{"get with invalid id", http.MethodGet, "/api/movies/invalid", "", http.StatusBadRequest},
+		{"update", http.MethodPut, existing, valid, http.StatusOK},
+		{"update missing", http.MethodPut, missing, valid, http.StatusNotFound},
+		{"update with invalid id", http.MethodPut, "/api/movies/invalid", valid, http.StatusBadRequest},
+		{"update with malformed json", http.MethodPut, existing, `{"title":`, http.StatusBadRequest},
+		{"update with invalid fields", http.MethodPut, existing, invalid, http.StatusUnprocessableEntity},
+		{"patch", http.MethodPatch, existing, `{"ticket_price":13.5}`, http.StatusOK},
+		{"patch missing", http.MethodPatch, missing, `{"ticket_price":13.5}`, http.StatusNotFound},
+		{"patch with invalid id", http.MethodPatch, "/api/movies/invalid", `{"ticket_price":13.5}`, http.StatusBadRequest},
+		{"patch with malformed json", http.MethodPatch, existing, `{"title":`, http.StatusBadRequest},
+		{"patch with null field", http.MethodPatch, existing, `{"title":null}`, http.StatusUnprocessableEntity},
+		{"delete missing", http.MethodDelete, missing, "", http.StatusNotFound},
+		{"delete with invalid id", http.MethodDelete, "/api/movies/invalid", "", http.StatusBadRequest},
+		{"delete", http.MethodDelete, existing, "", http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := doRequest(t, h, tc.method, tc.path, tc.body)
+
+			assert.Equal(t, tc.status, resp.StatusCode)
+			if tc.status >= http.StatusBadRequest {
+				assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+				var problem client.Problem
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+				assert.Equal(t, tc.status, problem.Status)
+				assert.NotEmpty(t, problem.Type)
+				assert.NotEmpty(t, problem.Title)
+				assert.Equal(t, strings.SplitN(tc.path, "?", 2)[0], problem.Instance)
+				assert.NotEmpty(t, problem.RequestID)
+			}
+		})
+	}
+}
+
+func TestGetMovie(t *testing.T) {
+	h := apitest.New(t)
+	request := newCreateMovieRequest("Get")
+	movie := createMovie(t, h, request)
+
+	t.Run("given movie exists, should return movie", func(t *testing.T) {
+		got, err := h.Client.GetMovie(context.Background(), movie.ID)
+
+		require.NoError(t, err)
+		assert.Equal(t, request.ID, got.ID.String())
+		assert.Equal(t, request.Title, got.Title)
+		assert.Equal(t, request.Director, got.Director)
+		assert.True(t, request.ReleaseDate.Equal(got.ReleaseDate))
+		assert.Equal(t, request.TicketPrice, got.TicketPrice)
+		assert.False(t, got.CreatedAt.IsZero())
+		assert.False(t, got.UpdatedAt.IsZero())
+	})
+
+	t.Run("given movie exists, should return movie fields", func(t *testing.T) {
+		resp := doRequest(t, h, http.MethodGet, "/api/movies/"+movie.ID.String(), "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, map[string]any{
+			"id":           movie.ID.String(),
+			"title":        "Get",
+			"director":     "Apitest",
+			"release_date": "2001-01-01T00:00:00Z",
+			"ticket_price": 12.5,
+			"created_at":   movie.CreatedAt.Format(time.RFC3339Nano),
+			"updated_at":   movie.UpdatedAt.Format(time.RFC3339Nano),
+			"version":      1.0,
+		}, body)
+		assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	})
+
+	t.Run("given movie does not exist, should return not found", func(t *testing.T) {
+		_, err := h.Client.GetMovie(context.Background(), uuid.New())
+
+		requireProblem(t, err, http.StatusNotFound)
+	})
+}
+
+func TestCreateMovie(t *testing.T) {
+	h := apitest.New(t)
+
+	t.Run("given id, should create movie with id", func(t *testing.T) {
+		request := newCreateMovieRequest("Create")
+
+		id, err := h.Client.CreateMovie(context.Background(), request)
+
+		require.NoError(t, err)
+		assert.Equal(t, request.ID, id.String())
+	})
+
+	t.Run("given movie created, should return ETag of its first version", func(t *testing.T) {
+		body, err := json.Marshal(newCreateMovieRequest("Create"))
+		require.NoError(t, err)
+
+		resp := doRequest(t, h, http.MethodPost, "/api/movies", string(body))
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	})
+
+	t.Run("given no id, should generate id", func(t *testing.T) {
+		request := newCreateMovieRequest("Create")
+		request.ID = ""
+
+		id, err := h.Client.CreateMovie(context.Background(), request)
+		require.NoError(t, err)
+
+		movie, err := h.Client.GetMovie(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "Create", movie.Title)
+	})
+
+	t.Run("given existing id, should return conflict", func(t *testing.T) {
+		request := newCreateMovieRequest("Create")
+		createMovie(t, h, request)
+
+		_, err := h.Client.CreateMovie(context.Background(), request)
+
+		requireProblem(t, err, http.StatusConflict)
+	})
+
+	t.Run("given ticket prices near upper bound, should create movie", func(t *testing.T) {
+		for _, ticketPrice := range []float64{1234567890.12, 9999999999.99} {
+			t.Run(strconv.FormatFloat(ticketPrice, 'f', -1, 64), func(t *testing.T) {
+				request := newCreateMovieRequest("Create")
+				request.TicketPrice = ticketPrice
+
+				movie := createMovie(t, h, request)
+
+				assert.Equal(t, ticketPrice, movie.TicketPrice)
+			})
+		}
+	})
+
+	t.Run("given invalid fields, should return field errors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			modify func(request *client.CreateMovieRequest)
+			field  string
+		}{
+			{"invalid id", func(request *client.CreateMovieRequest) { request.ID = "invalid" }, "id"},
+			{"empty title", func(request *client.CreateMovieRequest) { request.Title = " " }, "title"},
+			{"long title", func(request *client.CreateMovieRequest) { request.Title = strings.Repeat("a", 101) }, "title"},
+			{"empty director", func(request *client.CreateMovieRequest) { request.Director = "" }, "director"},
+			{"release date before cinema", func(request *client.CreateMovieRequest) {
+				request.ReleaseDate = time.Date(1887, time.December, 31, 0, 0, 0, 0, time.UTC)
+			}, "release_date"},
+			{"negative ticket price", func(request *client.CreateMovieRequest) { request.TicketPrice = -1 }, "ticket_price"},
+			{"ticket price with fractions of cents", func(request *client.CreateMovieRequest) { request.TicketPrice = 0.00001 }, "ticket_price"},
+			{"ticket price at upper bound", func(request *client.CreateMovieRequest) { request.TicketPrice = 1e10 }, "ticket_price"},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				request := newCreateMovieRequest("Create")
+				tc.modify(&request)
+
+				_, err := h.Client.CreateMovie(context.Background(), request)
+
+				problem := requireProblem(t, err, http.StatusUnprocessableEntity)
+				require.Len(t, problem.Errors, 1)
+				assert.Equal(t, tc.field, problem.Errors[0].Field)
+			})
+		}
+	})
+}
+
+func TestUpdateMovie(t *testing.T) {
+	h := apitest.New(t)
+	request := client.UpdateMovieRequest{
+		Title:       "Updated",
+		Director:    "Apitest Updated",
+		ReleaseDate: time.Date(2002, time.February, 2, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 15.75,
+	}
+
+	t.Run("given movie exists, should update movie", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Update"))
+
+		err := h.Client.UpdateMovie(context.Background(), movie.ID, request)
+		require.NoError(t, err)
+
+		got, err := h.Client.GetMovie(context.Background(), movie.ID)
+		require.NoError(t, err)
+		assert.Equal(t, request.Title, got.Title)
+		assert.Equal(t, request.Director, got.Director)
+		assert.True(t, request.ReleaseDate.Equal(got.ReleaseDate))
+		assert.Equal(t, request.TicketPrice, got.TicketPrice)
+		assert.True(t, movie.CreatedAt.Equal(got.CreatedAt))
+	})
+
+	t.Run("given movie updated, should return ETag of the new version", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Update"))
+		body, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		resp := doRequest(t, h, http.MethodPut, "/api/movies/"+movie.ID.String(), string(body))
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		got, err := h.Client.GetMovie(context.Background(), movie.ID)
+		require.NoError(t, err)
+		assert.Equal(t, movie.Version+1, got.Version)
+		assert.Equal(t, `"`+strconv.FormatInt(got.Version, 10)+`"`, resp.Header.Get("ETag"))
+	})
+
+	t.Run("given movie does not exist, should return not found", func(t *testing.T) {
+		err := h.Client.UpdateMovie(context.Background(), uuid.New(), request)
+
+		requireProblem(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given invalid fields, should return field errors", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Update"))
+
+		err := h.Client.UpdateMovie(context.Background(), movie.ID, client.UpdateMovieRequest{})
+
+		problem := requireProblem(t, err, http.StatusUnprocessableEntity)
+		var fields []string
+		for _, fe := range problem.Errors {
+			fields = append(fields, fe.Field)
+		}
+		assert.ElementsMatch(t, []string{"title", "director", "release_date"}, fields)
+	})
+}
+
+func TestPatchMovie(t *testing.T) {
+	h := apitest.New(t)
+	title := "Patched"
+	ticketPrice := 20.0
+
+	t.Run("given movie exists, should only update patched fields", func(t *testing.T) {
+		request := newCreateMovieRequest("Patch")
+		movie := createMovie(t, h, request)
+
+		err := h.Client.PatchMovie(context.Background(), movie.ID, client.PatchMovieRequest{Title: &title, TicketPrice: &ticketPrice})
+		require.NoError(t, err)
+
+		got, err := h.Client.GetMovie(context.Background(), movie.ID)
+		require.NoError(t, err)
+		assert.Equal(t, title, got.Title)
+		assert.Equal(t, request.Director, got.Director)
+		assert.True(t, request.ReleaseDate.Equal(got.ReleaseDate))
+		assert.Equal(t, ticketPrice, got.TicketPrice)
+		assert.Equal(t, movie.Version+1, got.Version)
+	})
+
+	t.Run("given movie patched, should return ETag of the new version", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Patch"))
+
+		resp := doRequest(t, h, http.MethodPatch, "/api/movies/"+movie.ID.String(), `{"title":"Patched"}`)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		got, err := h.Client.GetMovie(context.Background(), movie.ID)
+		require.NoError(t, err)
+		assert.Equal(t, movie.Version+1, got.Version)
+		assert.Equal(t, `"`+strconv.FormatInt(got.Version, 10)+`"`, resp.Header.Get("ETag"))
+	})
+
+	t.Run("given If-Match is stale, should return precondition failed", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Patch"))
+		request := client.PatchMovieRequest{Title: &title, Version: movie.Version}
+		require.NoError(t, h.Client.PatchMovie(context.Background(), movie.ID, request))
+
+		err := h.Client.PatchMovie(context.Background(), movie.ID, request)
+
+		requireProblem(t, err, http.StatusPreconditionFailed)
+	})
+
+	t.Run("given movie does not exist, should return not found", func(t *testing.T) {
+		err := h.Client.PatchMovie(context.Background(), uuid.New(), client.PatchMovieRequest{Title: &title})
+
+		requireProblem(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given empty patch, should keep the version and return its ETag", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Patch"))
+
+		resp := doRequest(t, h, http.MethodPatch, "/api/movies/"+movie.ID.String(), `{}`)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `"`+strconv.FormatInt(movie.Version, 10)+`"`, resp.Header.Get("ETag"))
+		got, err := h.Client.GetMovie(context.Background(), movie.ID)
+		require.NoError(t, err)
+		assert.Equal(t, movie.Version, got.Version)
+		assert.True(t, movie.UpdatedAt.Equal(got.UpdatedAt))
+	})
+
+	t.Run("given empty patch and stale If-Match, should return precondition failed", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Patch"))
+		require.NoError(t, h.Client.PatchMovie(context.Background(), movie.ID, client.PatchMovieRequest{Title: &title}))
+
+		err := h.Client.PatchMovie(context.Background(), movie.ID, client.PatchMovieRequest{Version: movie.Version})
+
+		requireProblem(t, err, http.StatusPreconditionFailed)
+	})
+
+	t.Run("given content type is not merge patch, should return unsupported media type", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Patch"))
+
+		resp := doRequestWithContentType(t, h, http.MethodPatch, "/api/movies/"+movie.ID.String(), "application/json", `{"title":"Patched"}`)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	})
+
+	t.Run("given invalid patch, should return field errors", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Patch"))
+		tests := []struct {
+			name   string
+			body   string
+			fields []string
+		}{
+			{"null field", `{"director":null}`, []string{"director"}},
+			{"read only field", `{"id":"00000000-0000-0000-0000-000000000000","created_at":"2001-01-01T00:00:00Z"}`, []string{"created_at", "id"}},
+			{"wrong type", `{"ticket_price":"free"}`, []string{"ticket_price"}},
+			{"invalid values", `{"title":" ","release_date":"1887-12-31T00:00:00Z"}`, []string{"release_date", "title"}},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				resp := doRequest(t, h, http.MethodPatch, "/api/movies/"+movie.ID.String(), tc.body)
+				require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+				var problem client.Problem
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+				var fields []string
+				for _, fe := range problem.Errors {
+					fields = append(fields, fe.Field)
+				}
+				assert.Equal(t, tc.fields, fields)
+			})
+		}
+
+		got, err := h.Client.GetMovie(context.Background(), movie.ID)
+		require.NoError(t, err)
+		assert.Equal(t, movie.Version, got.Version)
+	})
+}
+
+func TestDeleteMovie(t *testing.T) {
+	h := apitest.New(t)
+
+	t.Run("given movie exists, should delete movie", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Delete"))
+
+		err := h.Client.DeleteMovie(context.Background(), movie.ID, 0)
+		require.NoError(t, err)
+
+		_, err = h.Client.GetMovie(context.Background(), movie.ID)
+		requireProblem(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given movie does not exist, should return not found", func(t *testing.T) {
+		err := h.Client.DeleteMovie(context.Background(), uuid.New(), 0)
+
+		requireProblem(t, err, http.StatusNotFound)
+	})
+}
+
+func TestConditionalRequests(t *testing.T) {
+	h := apitest.New(t)
+	request := client.UpdateMovieRequest{
+		Title:       "Updated",
+		Director:    "Apitest",
+		ReleaseDate: time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 12.5,
+	}
+
+	t.Run("given If-Match matches, should update movie", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Conditional"))
+		request := request
+		request.Version = movie.Version
+
+		err := h.Client.UpdateMovie(context.Background(), movie.ID, request)
+		require.NoError(t, err)
+
+		got, err := h.Client.GetMovie(context.Background(), movie.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Updated", got.Title)
+		assert.Equal(t, movie.Version+1, got.Version)
+	})
+
+	t.Run("given If-Match is stale, should return precondition failed", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Conditional"))
+		request := request
+		request.Version = movie.Version
+		require.NoError(t, h.Client.UpdateMovie(context.Background(), movie.ID, request))
+
+		err := h.Client.UpdateMovie(context.Background(), movie.ID, request)
+		requireProblem(t, err, http.StatusPreconditionFailed)
+
+		err = h.Client.DeleteMovie(context.Background(), movie.ID, movie.Version)
+		requireProblem(t, err, http.StatusPreconditionFailed)
+	})
+
+	t.Run("given If-Match and movie does not exist, should return not found", func(t *testing.T) {
+		request := request
+		request.Version = 1
+
+		err := h.Client.UpdateMovie(context.Background(), uuid.New(), request)
+
+		requireProblem(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given If-Match * and movie does not exist, should return precondition failed", func(t *testing.T) {
+		body, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			req, err := http.NewRequest(method, h.Server.URL+"/api/movies/"+uuid.NewString(), strings.NewReader(string(body)))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if method == http.MethodPatch {
+				req.Header.Set("Content-Type", "application/merge-patch+json")
+			}
+			req.Header.Set("If-Match", "*")
+
+			resp, err := h.Server.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode, method)
+		}
+	})
+
+	t.Run("given If-Match matches, should delete movie", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Conditional"))
+
+		err := h.Client.DeleteMovie(context.Background(), movie.ID, movie.Version)
+		require.NoError(t, err)
+
+		_, err = h.Client.GetMovie(context.Background(), movie.ID)
+		requireProblem(t, err, http.StatusNotFound)
+	})
+
+	t.Run("given If-Match header, should compare strong entity tags", func(t *testing.T) {
+		movie := createMovie(t, h, newCreateMovieRequest("Conditional"))
+		tests := []struct {
+			ifMatch string
+			status  int
+		}{
+			{`W/"1"`, http.StatusPreconditionFailed},
+			{`1`, http.StatusPreconditionFailed},
+			{`"one"`, http.StatusPreconditionFailed},
+			{`"1", "2"`, http.StatusPreconditionFailed},
+			{`*`, http.StatusOK},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.ifMatch, func(t *testing.T) {
+				req, err := http.NewRequest(http.MethodDelete, h.Server.URL+"/api/movies/"+movie.ID.String(), nil)
+				require.NoError(t, err)
+				req.Header.Set("If-Match", tc.ifMatch)
+
+				resp, err := h.Server.Client().Do(req)
+				require.NoError(t, err)
+				resp.Body.Close()
+
+				assert.Equal(t, tc.status, resp.StatusCode)
+			})
+		}
+	})
+}
+
+func TestListMovies(t *testing.T) {
+	h := apitest.New(t)
+	var movies []client.Movie
+	for i, title := range []string{"Echo", "Charlie", "Alpha", "Delta", "Bravo"} {
+		request := newCreateMovieRequest(title)
+		request.TicketPrice = float64(10 + i)
+		if i%2 == 0 {
+			request.Director = "Apitest Even"
+		}
+		movies = append(movies, createMovie(t, h, request))
+	}
+	echo, charlie, alpha, delta, bravo := movies[0], movies[1], movies[2], movies[3], movies[4]
+
+	listAll := func(t *testing.T, options client.ListMoviesOptions) []uuid.UUID {
+		t.Helper()
+
+		var ids []uuid.UUID
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, len(movies), "expected cursor to advance")
+
+			page, err := h.Client.ListMovies(context.Background(), options)
+			require.NoError(t, err)
+			ids = append(ids, movieIDs(page.Movies)...)
+			if page.NextCursor == "" {
+				return ids
+			}
+			options.Cursor = page.NextCursor
+		}
+	}
+
+	minTicketPrice := 11.0
+	maxTicketPrice := 13.0
+	tests := []struct {
+		name     string
+		options  client.ListMoviesOptions
+		expected []uuid.UUID
+	}{
+		{"default sort", client.ListMoviesOptions{}, []uuid.UUID{echo.ID, charlie.ID, alpha.ID, delta.ID, bravo.ID}},
+		{"paged", client.ListMoviesOptions{Limit: 2}, []uuid.UUID{echo.ID, charlie.ID, alpha.ID, delta.ID, bravo.ID}},
+		{"sorted by title", client.ListMoviesOptions{Limit: 2, Sort: "title"}, []uuid.UUID{alpha.ID, bravo.ID, charlie.ID, delta.ID, echo.ID}},
+		{"sorted by ticket price descending", client.ListMoviesOptions{Limit: 3, Sort: "-ticket_price"}, []uuid.UUID{bravo.ID, delta.ID, alpha.ID, charlie.ID, echo.ID}},
+		{"filtered by director", client.ListMoviesOptions{Limit: 1, Sort: "title", Director: "apitest even"}, []uuid.UUID{alpha.ID, bravo.ID, echo.ID}},
+		{"filtered by ticket price", client.ListMoviesOptions{Sort: "title", MinTicketPrice: &minTicketPrice, MaxTicketPrice: &maxTicketPrice}, []uuid.UUID{alpha.ID, charlie.ID, delta.ID}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, listAll(t, tc.options))
+		})
+	}
+
+	t.Run("given cursor for another sort, should return bad request", func(t *testing.T) {
+		page, err := h.Client.ListMovies(context.Background(), client.ListMoviesOptions{Limit: 1, Sort: "title"})
+		require.NoError(t, err)
+
+		_, err = h.Client.ListMovies(context.Background(), client.ListMoviesOptions{Limit: 1, Sort: "-title", Cursor: page.NextCursor})
+
+		requireProblem(t, err, http.StatusBadRequest)
+	})
+}
+
+func TestSearchMovies(t *testing.T) {
+	h := apitest.New(t)
+	matrix := createMovie(t, h, newCreateMovieRequest("The Matrix"))
+	reloaded := createMovie(t, h, newCreateMovieRequest("The Matrix Reloaded"))
+	createMovie(t, h, newCreateMovieRequest("Inception"))
+
+	tests := []struct {
+		name     string
+		q        string
+		limit    int
+		expected []uuid.UUID
+	}{
+		{"word", "matrix", 0, []uuid.UUID{matrix.ID, reloaded.ID}},
+		{"prefix", "MATR", 0, []uuid.UUID{matrix.ID, reloaded.ID}},
+		{"all words", "matrix reloaded", 0, []uuid.UUID{reloaded.ID}},
+		{"limit", "matrix", 1, []uuid.UUID{matrix.ID}},
+		{"no match", "memento", 0, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			movies, err := h.Client.SearchMovies(context.Background(), tc.q, tc.limit)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, movieIDs(movies))
+		})
+	}
+
+	t.Run("given blank query, should return bad request", func(t *testing.T) {
+		_, err := h.Client.SearchMovies(context.Background(), " ", 0)
+
+		requireProblem(t, err, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/auth"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/ratelimit"
+)
+
+// limitRate takes a token for the request from the bucket of its client and
+// route, reporting the bucket in the RateLimit-* headers. It must run after
+// authenticate and once the route is matched, i.e. with chi's With.
+func (s *Server) limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.takeRate(w, r, 1) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// takeRate takes cost tokens for r from the bucket of its client and route and
+// reports whether r may go on, rendering the problem if it may not. A failing
+// limiter lets requests through rather than take the API down with it.
+func (s *Server) takeRate(w http.ResponseWriter, r *http.Request, cost int) bool {
+	if s.limiter == nil {
+		return true
+	}
+
+	result, err := s.limiter.Take(r.Context(), r.Method+" "+routePattern(r), s.rateLimitClient(r), cost)
+	if err != nil {
+		requestLogger(r).Error("rate limiter failed", slog.Any("error", err))
+		return true
+	}
+
+	setRateLimitHeaders(w, result)
+	if !result.Allowed {
+		renderTooManyRequests(w, r, result)
+		return false
+	}
+	return true
+}
+
+// limitIP takes a token for the request from the bucket of its client IP
+// address before it is authenticated. Requests failing authentication never
+// reach limitRate, without this credentials could be guessed as fast as 401s
+// are served. It must run before authenticate, and only reports the bucket
+// once it is empty as limitRate reports the bucket of the route.
+func (s *Server) limitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := s.limiter.TakeIP(r.Context(), s.limiter.ClientIP(r))
+		if err != nil {
+			requestLogger(r).Error("rate limiter failed", slog.Any("error", err))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !result.Allowed {
+			setRateLimitHeaders(w, result)
+			renderTooManyRequests(w, r, result)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, result ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+}
+
+func renderTooManyRequests(w http.ResponseWriter, r *http.Request, result ratelimit.Result) {
+	w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+	renderError(w, r, ProblemTooManyRequests.New(errors.New("rate limit exceeded")))
+}
+
+// rateLimitClient identifies the client of r by its principal, or by its IP
+// address for anonymous reads.
+func (s *Server) rateLimitClient(r *http.Request) string {
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		return principal.Method + ":" + principal.Subject
+	}
+	return "ip:" + s.limiter.ClientIP(r)
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
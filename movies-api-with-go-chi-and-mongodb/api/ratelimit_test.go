@@ -0,0 +1,134 @@
+package api_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/api"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/auth"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/ratelimit"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRateLimitedServer returns a server allowing a burst of 2 requests per
+// client and route, 1 for listing movies, and of 5 requests per IP address
+// before authentication, that barely refill. It accepts adminAPIKey and
+// "other-key".
+func newRateLimitedServer(t *testing.T) *api.Server {
+	t.Helper()
+
+	var apiKeys []string
+	for name, key := range map[string]string{"admin": adminAPIKey, "other": "other-key"} {
+		sum := sha256.Sum256([]byte(key))
+		apiKeys = append(apiKeys, name+":"+hex.EncodeToString(sum[:]))
+	}
+	authenticator, err := auth.New(context.Background(), config.Auth{APIKeys: apiKeys})
+	require.NoError(t, err)
+
+	limiter, err := ratelimit.New(config.RateLimit{
+		Backend: ratelimit.MemoryBackend,
+		Rate:    0.001,
+		Burst:   2,
+		Routes:  []string{"GET /api/movies=0.001:1"},
+		IPRate:  0.001,
+		IPBurst: 5,
+	})
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return api.NewServer(config.HTTPServer{}, store.NewMemoryMoviesStore(), prometheus.NewRegistry(), logger, authenticator, limiter)
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Run("should respond with too many requests once the burst is used", func(t *testing.T) {
+		server := newRateLimitedServer(t)
+		path := "/api/movies/" + uuid.NewString()
+
+		w := serve(server, http.MethodGet, path, adminAPIKey, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+		assert.NotEmpty(t, w.Header().Get("RateLimit-Reset"))
+		assert.Empty(t, w.Header().Get("Retry-After"))
+
+		serve(server, http.MethodGet, path, adminAPIKey, "")
+
+		w = serve(server, http.MethodGet, path, adminAPIKey, "")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "1000", w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), api.ProblemTooManyRequests.Type)
+	})
+
+	t.Run("should limit each principal on its own", func(t *testing.T) {
+		server := newRateLimitedServer(t)
+
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodGet, "/api/movies", adminAPIKey, "").Code)
+		assert.Equal(t, http.StatusTooManyRequests, serve(server, http.MethodGet, "/api/movies", adminAPIKey, "").Code)
+		assert.Equal(t, http.StatusOK, serve(server, http.MethodGet, "/api/movies", "other-key", "").Code)
+	})
+
+	t.Run("should take a token per operation of a batch", func(t *testing.T) {
+		server := newRateLimitedServer(t)
+		deleteOp := func() string { return `{"op":"delete","id":"` + uuid.NewString() + `"}` }
+
+		w := serve(server, http.MethodPost, "/api/movies:batch", adminAPIKey, `{"mode":"best_effort","operations":[`+deleteOp()+`,`+deleteOp()+`]}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+		w = serve(server, http.MethodPost, "/api/movies:batch", adminAPIKey, `{"mode":"best_effort","operations":[`+deleteOp()+`]}`)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("should limit anonymous clients by IP address", func(t *testing.T) {
+		server := newRateLimitedServer(t)
+		serveFrom := func(remoteAddr string) int {
+			req := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+			req.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		assert.Equal(t, http.StatusOK, serveFrom("203.0.113.7:52000"))
+		assert.Equal(t, http.StatusTooManyRequests, serveFrom("203.0.113.7:52001"))
+		assert.Equal(t, http.StatusOK, serveFrom("203.0.113.8:52000"))
+	})
+
+	t.Run("should limit failed authentication attempts by IP address", func(t *testing.T) {
+		server := newRateLimitedServer(t)
+
+		for i := 0; i < 5; i++ {
+			w := serve(server, http.MethodGet, "/api/movies", "guessed-key", "")
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+
+		for _, apiKey := range []string{"guessed-key", adminAPIKey} {
+			w := serve(server, http.MethodGet, "/api/movies", apiKey, "")
+			assert.Equal(t, http.StatusTooManyRequests, w.Code)
+			assert.Equal(t, "5", w.Header().Get("RateLimit-Limit"))
+			assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("should leave health and metrics unlimited", func(t *testing.T) {
+		server := newRateLimitedServer(t)
+
+		for i := 0; i < 3; i++ {
+			w := serve(server, http.MethodGet, "/health/live", "", "")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		}
+	})
+}
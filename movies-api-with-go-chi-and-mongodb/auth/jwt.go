@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/config"
+)
+
+const (
+	// jwksRefreshInterval is how often the JWKS is read at most, when it has
+	// expired or a token is signed with an unknown key, e.g. after a rotation.
+	jwksRefreshInterval = 30 * time.Second
+	jwksFetchTimeout    = 10 * time.Second
+	// leeway allows for clock skew between the issuer and the server.
+	leeway = 30 * time.Second
+)
+
+// jwtVerifier verifies the signature, expiry, issuer and audience of bearer
+// tokens.
+type jwtVerifier struct {
+	secret []byte
+	jwks   *jwks
+	parser *jwt.Parser
+}
+
+func newJWTVerifier(ctx context.Context, config config.Auth) (*jwtVerifier, error) {
+	if config.JWTSecret == "" && config.JWKSFile == "" && config.JWKSURL == "" {
+		return nil, nil
+	}
+	if config.JWKSFile != "" && config.JWKSURL != "" {
+		return nil, fmt.Errorf("only one of AUTH_JWKS_FILE and AUTH_JWKS_URL can be set")
+	}
+	if config.JWTIssuer == "" || config.JWTAudience == "" {
+		return nil, fmt.Errorf("AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE are required to verify tokens")
+	}
+
+	v := &jwtVerifier{}
+	var methods []string
+	if config.JWTSecret != "" {
+		v.secret = []byte(config.JWTSecret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.JWKSFile != "" || config.JWKSURL != "" {
+		v.jwks = &jwks{
+			file:   config.JWKSFile,
+			url:    config.JWKSURL,
+			ttl:    config.JWKSCacheTTL,
+			client: &http.Client{Timeout: jwksFetchTimeout},
+		}
+		if err := v.jwks.refresh(ctx); err != nil {
+			return nil, err
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	v.parser = jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(config.JWTIssuer),
+		jwt.WithAudience(config.JWTAudience),
+		jwt.WithLeeway(leeway),
+	)
+	return v, nil
+}
+
+func (v *jwtVerifier) verify(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		switch token.Method {
+		case jwt.SigningMethodHS256:
+			return v.secret, nil
+		case jwt.SigningMethodRS256:
+			kid, _ := token.Header["kid"].(string)
+			return v.jwks.key(ctx, kid)
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// jwks caches the RSA keys of a JSON Web Key Set read from a file or fetched
+// from a URL, they are read again once ttl has passed.
+type jwks struct {
+	file   string
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// refreshing is closed when the refresh in flight, if any, is done
+	refreshing chan struct{}
+}
+
+var errUnknownKey = errors.New("token signed with an unknown key")
+
+// key returns the key with id kid, a token without a kid can be verified if the
+// set has a single key. Only requests for a key missing from the set wait for
+// a refresh, the others are verified with the keys read last meanwhile.
+func (j *jwks) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	key := j.lookup(kid)
+	stale := time.Since(j.fetchedAt) > j.ttl || key == nil
+	if stale && j.refreshing == nil && time.Since(j.attemptedAt) > jwksRefreshInterval {
+		j.refreshing = make(chan struct{})
+		go j.refreshDetached(j.refreshing)
+	}
+	refreshing := j.refreshing
+	j.mu.Unlock()
+
+	if key != nil {
+		return key, nil
+	}
+	if refreshing != nil {
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if key := j.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, errUnknownKey
+}
+
+func (j *jwks) lookup(kid string) *rsa.PublicKey {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key
+		}
+	}
+	return j.keys[kid]
+}
+
+// refreshDetached refreshes the set for the requests waiting on done. It is not
+// bound to their contexts, a client going away must not abort the refresh for
+// the others, but to jwksFetchTimeout.
+func (j *jwks) refreshDetached(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	if err := j.refresh(ctx); err != nil {
+		slog.Warn("jwks.refresh failed, keeping the keys read last", slog.Any("error", err))
+	}
+
+	j.mu.Lock()
+	j.refreshing = nil
+	j.mu.Unlock()
+	close(done)
+}
+
+// refresh reads the set again and swaps the keys in, the keys read last are
+// kept if it fails so an unavailable issuer does not reject every token. mu is
+// only held for the swap, not while the set is read.
+func (j *jwks) refresh(ctx context.Context) error {
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.attemptedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	j.keys = keys
+	j.fetchedAt = j.attemptedAt
+	return nil
+}
+
+func (j *jwks) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	data, err := j.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+	return keys, nil
+}
+
+func (j *jwks) read(ctx context.Context) ([]byte, error) {
+	if j.file != "" {
+		return os.ReadFile(j.file)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", j.url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// parseJWKS returns the RSA signing keys of the set by kid, other keys are
+// skipped.
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != jwt.SigningMethodRS256.Alg()) {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid modulus: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid exponent: %w", k.Kid, err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("key %q: invalid exponent", k.Kid)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	}
+	return keys, nil
+}
@@ -0,0 +1,28 @@
+package integrationtests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/store"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/store/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMongoMoviesStore(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	if cfg.Database.DatabaseURL == "" {
+		t.Skip("database not configured: DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	sut, err := store.NewMongoMoviesStore(ctx, cfg.Database)
+	require.NoError(t, err)
+	defer sut.Close(ctx)
+
+	storetest.Run(t, func(t *testing.T) store.Interface {
+		return sut
+	})
+}
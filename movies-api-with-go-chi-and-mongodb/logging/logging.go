@@ -0,0 +1,35 @@
+// Package logging builds the slog logger the server writes its access and
+// error logs to.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/config"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New returns a logger writing to w at the level and in the format selected
+// by config.
+func New(w io.Writer, config config.Logging) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q, must be debug, info, warn or error", config.Level)
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	switch config.Format {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown LOG_FORMAT %q, must be %s or %s", config.Format, FormatJSON, FormatText)
+	}
+}
@@ -2,54 +2,253 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/config"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 )
 
+// MongoDriver is the STORE_DRIVER name of MongoMoviesStore.
+const MongoDriver = "mongo"
+
+func init() {
+	Register(MongoDriver, Driver{
+		Validate: validateMongoConfig,
+		Open: func(ctx context.Context, config config.Database) (Closer, error) {
+			s, err := NewMongoMoviesStore(ctx, config)
+			if err != nil {
+				return nil, err
+			}
+			return mongoCloser{s}, nil
+		},
+	})
+}
+
+func validateMongoConfig(config config.Database) error {
+	if err := requireDatabaseURL(config); err != nil {
+		return err
+	}
+	if _, err := connstring.ParseAndValidate(config.DatabaseURL); err != nil {
+		return fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+	if config.DatabaseName == "" {
+		return errors.New("DATABASE_NAME is required")
+	}
+	if _, err := readpref.ModeFromString(config.ReadPreference); err != nil {
+		return fmt.Errorf("invalid DATABASE_READ_PREFERENCE: %w", err)
+	}
+	return nil
+}
+
+// mongoCloser adapts MongoMoviesStore to Closer, disconnecting without a
+// deadline.
+type mongoCloser struct {
+	*MongoMoviesStore
+}
+
+func (c mongoCloser) Close() error {
+	return c.MongoMoviesStore.Close(context.Background())
+}
+
+// statementMonitor records the shape of every command sent to the server as
+// the db.statement of the store span in its context.
+var statementMonitor = &event.CommandMonitor{
+	Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+		recordStatement(ctx, commandShape(evt.Command))
+	},
+}
+
+// commandFields are added to commands by the driver rather than the store,
+// they are left out of their shape.
+var commandFields = map[string]bool{
+	"$clusterTime":         true,
+	"$db":                  true,
+	"$readPreference":      true,
+	"apiDeprecationErrors": true,
+	"apiStrict":            true,
+	"apiVersion":           true,
+	"autocommit":           true,
+	"lsid":                 true,
+	"readConcern":          true,
+	"startTransaction":     true,
+	"txnNumber":            true,
+	"writeConcern":         true,
+}
+
+// commandShape returns command as extended JSON with its values replaced by
+// ?, e.g. {"find":"movies","filter":{"_id":"?"}}. The first field names the
+// command and its collection so it is kept. Of the batches of documents,
+// updates and deletes only the first is kept, the rest have the same shape.
+func commandShape(command bson.Raw) string {
+	elements, err := command.Elements()
+	if err != nil {
+		return ""
+	}
+
+	shape := bson.D{}
+	for i, element := range elements {
+		key := element.Key()
+		if commandFields[key] {
+			continue
+		}
+		if i == 0 {
+			shape = append(shape, bson.E{Key: key, Value: element.Value()})
+			continue
+		}
+		value := element.Value()
+		if values, ok := value.ArrayOK(); ok && (key == "documents" || key == "updates" || key == "deletes") {
+			batch := bson.A{}
+			if first, err := values.IndexErr(0); err == nil {
+				batch = append(batch, valueShape(first.Value()))
+			}
+			shape = append(shape, bson.E{Key: key, Value: batch})
+			continue
+		}
+		shape = append(shape, bson.E{Key: key, Value: valueShape(value)})
+	}
+
+	data, err := bson.MarshalExtJSON(shape, false, false)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func valueShape(value bson.RawValue) any {
+	switch value.Type {
+	case bson.TypeEmbeddedDocument:
+		elements, _ := value.Document().Elements()
+		shape := bson.D{}
+		for _, element := range elements {
+			shape = append(shape, bson.E{Key: element.Key(), Value: valueShape(element.Value())})
+		}
+		return shape
+	case bson.TypeArray:
+		values, _ := value.Array().Values()
+		shape := bson.A{}
+		for _, v := range values {
+			shape = append(shape, valueShape(v))
+		}
+		return shape
+	default:
+		return "?"
+	}
+}
+
 type MongoMoviesStore struct {
-	database   config.Database
 	client     *mongo.Client
 	collection *mongo.Collection
+	// session is set on the copy of the store passed to WithTx, it is attached
+	// to the context of every operation so they run in its transaction.
+	session mongo.Session
 }
 
-func NewMongoMoviesStore(config config.Database) *MongoMoviesStore {
-	return &MongoMoviesStore{
-		database: config,
+func NewMongoMoviesStore(ctx context.Context, config config.Database) (*MongoMoviesStore, error) {
+	mode, err := readpref.ModeFromString(config.ReadPreference)
+	if err != nil {
+		return nil, err
+	}
+	readPreference, err := readpref.New(mode)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (s *MongoMoviesStore) connect(ctx context.Context) error {
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-
 	client, err := mongo.Connect(
 		ctx,
-		options.Client().ApplyURI(s.database.DatabaseURL).SetServerAPIOptions(serverAPI),
+		options.Client().
+			ApplyURI(config.DatabaseURL).
+			SetServerAPIOptions(serverAPI).
+			SetMaxPoolSize(config.MaxPoolSize).
+			SetServerSelectionTimeout(config.ServerSelectionTimeout).
+			SetReadPreference(readPreference).
+			SetMonitor(statementMonitor),
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	s.client = client
-	s.collection = s.client.Database(s.database.DatabaseName).Collection(s.database.MoviesCollectionName)
-	return nil
+	if err := client.Ping(ctx, readPreference); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	var hello helloResult
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	if err := hello.supportsTransactions(); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	collection := client.Database(config.DatabaseName).Collection(config.MoviesCollectionName)
+	if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "title", Value: "text"}, {Key: "director", Value: "text"}},
+		Options: options.Index().
+			SetName("movies_search").
+			SetWeights(bson.D{{Key: "title", Value: 2}, {Key: "director", Value: 1}}).
+			SetDefaultLanguage("none"),
+	}); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	// movies created before they were versioned start at the first version
+	if _, err := collection.UpdateMany(
+		ctx,
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"version": int64(1)}},
+	); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	return &MongoMoviesStore{
+		client:     client,
+		collection: collection,
+	}, nil
 }
 
-func (s *MongoMoviesStore) close(ctx context.Context) error {
-	return s.client.Disconnect(ctx)
+// helloResult is the part of the reply to the hello command telling a
+// replica set member or mongos apart from a standalone server.
+type helloResult struct {
+	SetName string `bson:"setName"`
+	Msg     string `bson:"msg"`
 }
 
-func (s *MongoMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
-	err := s.connect(ctx)
-	if err != nil {
-		return err
+// supportsTransactions fails for a standalone server, CreateMany and WithTx
+// run in transactions that need a replica set member or a mongos.
+func (h helloResult) supportsTransactions() error {
+	if h.SetName != "" || h.Msg == "isdbgrid" {
+		return nil
 	}
-	defer s.close(ctx)
+	return errors.New("MongoDB server at DATABASE_URL is a standalone mongod, transactions need a replica set member or mongos, see docker-compose.dev-env.yml for a single node replica set")
+}
+
+func (s *MongoMoviesStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// Ping pings a server selected by the read preference of the client.
+func (s *MongoMoviesStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, nil)
+}
 
+func (s *MongoMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
+	ctx = s.sessionContext(ctx)
 	movie := Movie{
 		ID:          createMovieParams.ID,
 		Title:       createMovieParams.Title,
@@ -58,6 +257,7 @@ func (s *MongoMoviesStore) Create(ctx context.Context, createMovieParams CreateM
 		TicketPrice: createMovieParams.TicketPrice,
 		CreatedAt:   time.Now().UTC(),
 		UpdatedAt:   time.Now().UTC(),
+		Version:     1,
 	}
 
 	if _, err := s.collection.InsertOne(ctx, movie); err != nil {
@@ -70,13 +270,48 @@ func (s *MongoMoviesStore) Create(ctx context.Context, createMovieParams CreateM
 	return nil
 }
 
-func (s *MongoMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
-	err := s.connect(ctx)
-	if err != nil {
-		return nil, err
+func (s *MongoMoviesStore) CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error {
+	ctx = s.sessionContext(ctx)
+	if len(createMoviesParams) == 0 {
+		return nil
+	}
+	if id, ok := duplicateMovieID(createMoviesParams); ok {
+		return &DuplicateKeyError{ID: id}
 	}
-	defer s.close(ctx)
 
+	now := time.Now().UTC()
+	movies := make([]interface{}, 0, len(createMoviesParams))
+	for _, p := range createMoviesParams {
+		movies = append(movies, Movie{
+			ID:          p.ID,
+			Title:       p.Title,
+			Director:    p.Director,
+			ReleaseDate: p.ReleaseDate,
+			TicketPrice: p.TicketPrice,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			Version:     1,
+		})
+	}
+
+	return s.inTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.collection.InsertMany(ctx, movies); err != nil {
+			var bulkWriteErr mongo.BulkWriteException
+			if errors.As(err, &bulkWriteErr) {
+				for _, writeErr := range bulkWriteErr.WriteErrors {
+					if mongo.IsDuplicateKeyError(writeErr) {
+						return &DuplicateKeyError{ID: createMoviesParams[writeErr.Index].ID}
+					}
+				}
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *MongoMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
+	ctx = s.sessionContext(ctx)
 	cur, err := s.collection.Find(ctx, bson.D{})
 	if err != nil {
 		return nil, err
@@ -91,13 +326,134 @@ func (s *MongoMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
 	return movies, nil
 }
 
-func (s *MongoMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
-	err := s.connect(ctx)
+func (s *MongoMoviesStore) List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error) {
+	ctx = s.sessionContext(ctx)
+	after := listMoviesParams.After
+	if after == nil {
+		after = &MovieCursor{}
+	}
+
+	keyset := bson.D{
+		{Key: "createdat", Value: after.CreatedAt},
+		{Key: "_id", Value: after.ID},
+	}
+	switch listMoviesParams.SortBy {
+	case "", SortByCreatedAt:
+	case SortByTitle:
+		keyset = append(bson.D{{Key: "title", Value: after.Title}}, keyset...)
+	case SortByReleaseDate:
+		keyset = append(bson.D{{Key: "releasedate", Value: after.ReleaseDate}}, keyset...)
+	case SortByTicketPrice:
+		keyset = append(bson.D{{Key: "ticketprice", Value: after.TicketPrice}}, keyset...)
+	default:
+		return MoviesPage{}, &ValidationError{Field: "sort", Message: fmt.Sprintf("unsupported sort field %q", listMoviesParams.SortBy)}
+	}
+
+	operator, direction := "$gt", 1
+	if listMoviesParams.Descending {
+		operator, direction = "$lt", -1
+	}
+
+	conditions := bson.A{}
+	if listMoviesParams.Director != "" {
+		conditions = append(conditions, bson.M{"director": primitive.Regex{
+			Pattern: "^" + regexp.QuoteMeta(listMoviesParams.Director) + "$",
+			Options: "i",
+		}})
+	}
+	if listMoviesParams.ReleaseDateFrom != nil {
+		conditions = append(conditions, bson.M{"releasedate": bson.M{"$gte": *listMoviesParams.ReleaseDateFrom}})
+	}
+	if listMoviesParams.ReleaseDateTo != nil {
+		conditions = append(conditions, bson.M{"releasedate": bson.M{"$lte": *listMoviesParams.ReleaseDateTo}})
+	}
+	if listMoviesParams.MinTicketPrice != nil {
+		conditions = append(conditions, bson.M{"ticketprice": bson.M{"$gte": *listMoviesParams.MinTicketPrice}})
+	}
+	if listMoviesParams.MaxTicketPrice != nil {
+		conditions = append(conditions, bson.M{"ticketprice": bson.M{"$lte": *listMoviesParams.MaxTicketPrice}})
+	}
+	if listMoviesParams.After != nil {
+		alternatives := bson.A{}
+		for i, key := range keyset {
+			alternative := bson.D{}
+			for _, previous := range keyset[:i] {
+				alternative = append(alternative, bson.E{Key: previous.Key, Value: previous.Value})
+			}
+			alternative = append(alternative, bson.E{Key: key.Key, Value: bson.M{operator: key.Value}})
+			alternatives = append(alternatives, alternative)
+		}
+		conditions = append(conditions, bson.M{"$or": alternatives})
+	}
+
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+
+	sort := bson.D{}
+	for _, key := range keyset {
+		sort = append(sort, bson.E{Key: key.Key, Value: direction})
+	}
+	findOptions := options.Find().SetSort(sort)
+	if listMoviesParams.Limit > 0 {
+		findOptions.SetLimit(int64(listMoviesParams.Limit + 1))
+	}
+
+	cur, err := s.collection.Find(ctx, filter, findOptions)
 	if err != nil {
-		return Movie{}, err
+		return MoviesPage{}, err
 	}
-	defer s.close(ctx)
+	defer cur.Close(ctx)
+
+	var movies []Movie
+	if err := cur.All(ctx, &movies); err != nil {
+		return MoviesPage{}, err
+	}
+
+	return nextPage(movies, listMoviesParams.Limit), nil
+}
+
+// Search uses the movies_search text index, each term is quoted so that all
+// of them have to match and results are ranked by text score.
+func (s *MongoMoviesStore) Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error) {
+	ctx = s.sessionContext(ctx)
+	terms, err := parseSearchTerms(searchMoviesParams.Query)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "title", Value: 1}, {Key: "createdat", Value: 1}, {Key: "_id", Value: 1}})
+	if searchMoviesParams.Limit > 0 {
+		findOptions.SetLimit(int64(searchMoviesParams.Limit))
+	}
+
+	cur, err := s.collection.Find(ctx, bson.M{"$text": bson.M{"$search": strings.Join(terms, " ")}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var movies []Movie
+	if err := cur.All(ctx, &movies); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
 
+func (s *MongoMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
+	ctx = s.sessionContext(ctx)
 	var movie Movie
 	if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&movie); err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -110,38 +466,142 @@ func (s *MongoMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, er
 }
 
 func (s *MongoMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
-	err := s.connect(ctx)
-	if err != nil {
-		return err
+	ctx = s.sessionContext(ctx)
+	filter := bson.M{"_id": id}
+	if updateMovieParams.ExpectedVersion > 0 {
+		filter["version"] = updateMovieParams.ExpectedVersion
 	}
-	defer s.close(ctx)
-
 	update := bson.M{
 		"$set": bson.M{
-			"Title":       updateMovieParams.Title,
-			"Director":    updateMovieParams.Director,
-			"ReleaseDate": updateMovieParams.ReleaseDate,
-			"TicketPrice": updateMovieParams.TicketPrice,
-			"UpdatedAt":   time.Now().UTC(),
+			"title":       updateMovieParams.Title,
+			"director":    updateMovieParams.Director,
+			"releasedate": updateMovieParams.ReleaseDate,
+			"ticketprice": updateMovieParams.TicketPrice,
+			"updatedat":   time.Now().UTC(),
 		},
+		"$inc": bson.M{"version": 1},
 	}
-	if _, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
 		return err
 	}
+	if result.MatchedCount == 0 {
+		return s.noDocumentsMatchedError(ctx, id, updateMovieParams.ExpectedVersion)
+	}
 
 	return nil
 }
 
-func (s *MongoMoviesStore) Delete(ctx context.Context, id uuid.UUID) error {
-	err := s.connect(ctx)
+func (s *MongoMoviesStore) Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error {
+	ctx = s.sessionContext(ctx)
+	filter := bson.M{"_id": id}
+	if patchMovieParams.ExpectedVersion > 0 {
+		filter["version"] = patchMovieParams.ExpectedVersion
+	}
+	set := bson.M{"updatedat": time.Now().UTC()}
+	if patchMovieParams.Title != nil {
+		set["title"] = *patchMovieParams.Title
+	}
+	if patchMovieParams.Director != nil {
+		set["director"] = *patchMovieParams.Director
+	}
+	if patchMovieParams.ReleaseDate != nil {
+		set["releasedate"] = *patchMovieParams.ReleaseDate
+	}
+	if patchMovieParams.TicketPrice != nil {
+		set["ticketprice"] = *patchMovieParams.TicketPrice
+	}
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	result, err := s.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
-	defer s.close(ctx)
+	if result.MatchedCount == 0 {
+		return s.noDocumentsMatchedError(ctx, id, patchMovieParams.ExpectedVersion)
+	}
+
+	return nil
+}
 
-	if _, err := s.collection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+func (s *MongoMoviesStore) Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error {
+	ctx = s.sessionContext(ctx)
+	filter := bson.M{"_id": id}
+	if deleteMovieParams.ExpectedVersion > 0 {
+		filter["version"] = deleteMovieParams.ExpectedVersion
+	}
+	result, err := s.collection.DeleteOne(ctx, filter)
+	if err != nil {
 		return err
 	}
+	if result.DeletedCount == 0 {
+		return s.noDocumentsMatchedError(ctx, id, deleteMovieParams.ExpectedVersion)
+	}
 
 	return nil
 }
+
+func (s *MongoMoviesStore) Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error) {
+	return batch(ctx, s, operations, atomic)
+}
+
+// WithTx runs fn in a session transaction, like any Mongo transaction fn is
+// retried if the transaction fails with a transient error.
+func (s *MongoMoviesStore) WithTx(ctx context.Context, fn func(tx Interface) error) error {
+	return s.inTransaction(s.sessionContext(ctx), func(ctx context.Context) error {
+		return fn(&MongoMoviesStore{
+			client:     s.client,
+			collection: s.collection,
+			session:    mongo.SessionFromContext(ctx),
+		})
+	})
+}
+
+// sessionContext returns ctx with the session of the transaction the store is
+// bound to, if any.
+func (s *MongoMoviesStore) sessionContext(ctx context.Context) context.Context {
+	if s.session == nil {
+		return ctx
+	}
+	return mongo.NewSessionContext(ctx, s.session)
+}
+
+// inTransaction runs fn in a transaction, the session is passed to fn through
+// ctx and joined if ctx already has one. Transactions need the server to be a
+// replica set member or mongos, NewMongoMoviesStore checks the server is one.
+func (s *MongoMoviesStore) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// noDocumentsMatchedError tells apart a missing movie from a version mismatch
+// when a conditional write did not match any documents.
+func (s *MongoMoviesStore) noDocumentsMatchedError(ctx context.Context, id uuid.UUID, expectedVersion int64) error {
+	if expectedVersion == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	count, err := s.collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	return &VersionMismatchError{ID: id, ExpectedVersion: expectedVersion}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+)
+
+func TestCommandShape(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  bson.D
+		expected string
+	}{
+		{
+			name: "should replace the values of filters",
+			command: bson.D{
+				{Key: "find", Value: "movies"},
+				{Key: "filter", Value: bson.M{"_id": uuid.NewString()}},
+				{Key: "lsid", Value: bson.M{"id": "session"}},
+				{Key: "$db", Value: "MoviesStore"},
+			},
+			expected: `{"find":"movies","filter":{"_id":"?"}}`,
+		},
+		{
+			name: "should keep every condition",
+			command: bson.D{
+				{Key: "find", Value: "movies"},
+				{Key: "filter", Value: bson.M{"$or": bson.A{bson.M{"title": bson.M{"$gt": "A"}}, bson.M{"director": "B"}}}},
+				{Key: "limit", Value: 10},
+			},
+			expected: `{"find":"movies","filter":{"$or":[{"title":{"$gt":"?"}},{"director":"?"}]},"limit":"?"}`,
+		},
+		{
+			name: "should keep the first document of a batch",
+			command: bson.D{
+				{Key: "insert", Value: "movies"},
+				{Key: "documents", Value: bson.A{bson.M{"title": "A"}, bson.M{"title": "B"}}},
+				{Key: "ordered", Value: true},
+			},
+			expected: `{"insert":"movies","documents":[{"title":"?"}],"ordered":"?"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			command, err := bson.Marshal(tc.command)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expected, commandShape(command))
+		})
+	}
+}
+
+func TestHelloResultSupportsTransactions(t *testing.T) {
+	tests := []struct {
+		name     string
+		hello    helloResult
+		expected bool
+	}{
+		{"replica set member", helloResult{SetName: "rs0"}, true},
+		{"mongos", helloResult{Msg: "isdbgrid"}, true},
+		{"standalone", helloResult{}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.hello.supportsTransactions()
+			assert.Equal(t, tc.expected, err == nil)
+		})
+	}
+}
+
+func TestStatementMonitor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+	command, err := bson.Marshal(bson.D{{Key: "delete", Value: "movies"}, {Key: "deletes", Value: bson.A{bson.D{{Key: "q", Value: bson.M{"_id": "id"}}, {Key: "limit", Value: 1}}}}})
+	require.NoError(t, err)
+
+	ctx, span := tracer.Start(context.Background(), "store.Delete")
+	statementMonitor.Started(ctx, &event.CommandStartedEvent{CommandName: "delete", Command: command})
+	span.End()
+
+	require.Len(t, recorder.Ended(), 1)
+	assert.Contains(t, recorder.Ended()[0].Attributes(), semconv.DBStatement(`{"delete":"movies","deletes":[{"q":{"_id":"?"},"limit":"?"}]}`))
+}
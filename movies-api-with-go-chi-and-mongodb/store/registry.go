@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mongodb/config"
+)
+
+// MemoryDriver is the STORE_DRIVER name of MemoryMoviesStore.
+const MemoryDriver = "memory"
+
+// Closer is a store holding connections that Close releases.
+type Closer interface {
+	Interface
+	Close() error
+}
+
+// Driver opens a store for a STORE_DRIVER name. Validate checks the settings
+// the store needs, so a misconfiguration is reported before connecting.
+type Driver struct {
+	Validate func(config config.Database) error
+	Open     func(ctx context.Context, config config.Database) (Closer, error)
+}
+
+// drivers are the stores this module can open, the memory driver and the
+// driver of the database the module is built for.
+var drivers = map[string]Driver{}
+
+// Register makes a driver available to Open by name, it is meant to be called
+// from init and panics if the name is already registered.
+func Register(name string, driver Driver) {
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("store: Register called twice for driver %s", name))
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns the sorted names of the registered drivers.
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks config against the driver named by config.Driver.
+func Validate(config config.Database) error {
+	driver, ok := drivers[config.Driver]
+	if !ok {
+		return fmt.Errorf("unknown STORE_DRIVER %q, registered drivers are %s", config.Driver, strings.Join(Drivers(), ", "))
+	}
+	if driver.Validate == nil {
+		return nil
+	}
+	if err := driver.Validate(config); err != nil {
+		return fmt.Errorf("store driver %s: %w", config.Driver, err)
+	}
+	return nil
+}
+
+// Open validates config and opens the store of the driver named by
+// config.Driver.
+func Open(ctx context.Context, config config.Database) (Closer, error) {
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	return drivers[config.Driver].Open(ctx, config)
+}
+
+// requireDatabaseURL is the validation shared by the database drivers.
+func requireDatabaseURL(config config.Database) error {
+	if config.DatabaseURL == "" {
+		return errors.New("DATABASE_URL is required")
+	}
+	return nil
+}
+
+// nopCloser adapts a store without connections to Closer.
+type nopCloser struct {
+	Interface
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func init() {
+	Register(MemoryDriver, Driver{
+		Open: func(ctx context.Context, config config.Database) (Closer, error) {
+			return nopCloser{NewMemoryMoviesStore()}, nil
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/api/apitest"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider recording every span as the global
+// one, the previous provider and propagator are restored when the test completes.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return recorder
+}
+
+func TestTracing(t *testing.T) {
+	recorder := recordSpans(t)
+	h := apitest.New(t)
+
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/movies/"+uuid.NewString(), nil)
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-"+traceID.String()+"-00f067aa0ba902b7-01")
+	resp, err := h.Server.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	serverSpan, ok := spans["GET /api/movies/{id}"]
+	require.True(t, ok, "server span named after the route pattern")
+	storeSpan, ok := spans["store.GetByID"]
+	require.True(t, ok, "store span")
+
+	t.Run("should continue the trace of the traceparent header", func(t *testing.T) {
+		assert.Equal(t, traceID, serverSpan.SpanContext().TraceID())
+		assert.Equal(t, "00f067aa0ba902b7", serverSpan.Parent().SpanID().String())
+		assert.True(t, serverSpan.Parent().IsRemote())
+	})
+
+	t.Run("should describe the request", func(t *testing.T) {
+		assert.Equal(t, trace.SpanKindServer, serverSpan.SpanKind())
+		assert.Contains(t, serverSpan.Attributes(), semconv.HTTPMethod(http.MethodGet))
+		assert.Contains(t, serverSpan.Attributes(), semconv.HTTPRoute("/api/movies/{id}"))
+		assert.Contains(t, serverSpan.Attributes(), semconv.HTTPStatusCode(http.StatusNotFound))
+		assert.Equal(t, codes.Unset, serverSpan.Status().Code)
+	})
+
+	t.Run("should trace store calls as children of the request", func(t *testing.T) {
+		assert.Equal(t, serverSpan.SpanContext().SpanID(), storeSpan.Parent().SpanID())
+		assert.Equal(t, traceID, storeSpan.SpanContext().TraceID())
+		assert.Contains(t, storeSpan.Attributes(), attribute.String("store.error", "not_found"))
+		assert.Contains(t, storeSpan.Attributes(), semconv.DBSystemKey.String(store.MemoryDriver))
+	})
+}
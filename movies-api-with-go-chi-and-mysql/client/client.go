@@ -0,0 +1,360 @@
+// Package client provides a typed Go client for the movies API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Movie struct {
+	ID          uuid.UUID `json:"id"`
+	Title       string    `json:"title"`
+	Director    string    `json:"director"`
+	ReleaseDate time.Time `json:"release_date"`
+	TicketPrice float64   `json:"ticket_price"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int64     `json:"version"`
+}
+
+type CreateMovieRequest struct {
+	ID          string    `json:"id,omitempty"`
+	Title       string    `json:"title"`
+	Director    string    `json:"director"`
+	ReleaseDate time.Time `json:"release_date"`
+	TicketPrice float64   `json:"ticket_price"`
+}
+
+type UpdateMovieRequest struct {
+	Title       string    `json:"title"`
+	Director    string    `json:"director"`
+	ReleaseDate time.Time `json:"release_date"`
+	TicketPrice float64   `json:"ticket_price"`
+	// Version is sent as If-Match so the update fails if the movie has been
+	// changed since, zero updates regardless of the version.
+	Version int64 `json:"-"`
+}
+
+// PatchMovieRequest is a JSON merge patch of a movie, nil fields are left out
+// of the patch and keep their current value.
+type PatchMovieRequest struct {
+	Title       *string    `json:"title,omitempty"`
+	Director    *string    `json:"director,omitempty"`
+	ReleaseDate *time.Time `json:"release_date,omitempty"`
+	TicketPrice *float64   `json:"ticket_price,omitempty"`
+	// Version is sent as If-Match, see UpdateMovieRequest.
+	Version int64 `json:"-"`
+}
+
+// ListMoviesOptions are the query parameters of GET /api/movies, zero values
+// are left out so the server defaults apply.
+type ListMoviesOptions struct {
+	Limit           int
+	Cursor          string
+	Sort            string // field name, prefixed with - for descending order
+	Director        string
+	ReleaseDateFrom *time.Time
+	ReleaseDateTo   *time.Time
+	MinTicketPrice  *float64
+	MaxTicketPrice  *float64
+}
+
+type MoviesPage struct {
+	Movies []Movie
+	// NextCursor is set to ListMoviesOptions.Cursor to fetch the next page, it
+	// is empty on the last page.
+	NextCursor string
+}
+
+// BatchOperation is a single write in a batch, build it with CreateOperation,
+// UpdateOperation or DeleteOperation.
+type BatchOperation struct {
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Version int64  `json:"version,omitempty"`
+	Movie   any    `json:"movie,omitempty"`
+}
+
+func CreateOperation(request CreateMovieRequest) BatchOperation {
+	return BatchOperation{Op: "create", Movie: request}
+}
+
+// UpdateOperation updates the movie, conditionally on request.Version if set.
+func UpdateOperation(id uuid.UUID, request UpdateMovieRequest) BatchOperation {
+	return BatchOperation{Op: "update", ID: id.String(), Version: request.Version, Movie: request}
+}
+
+// DeleteOperation deletes the movie, conditionally on version if not zero.
+func DeleteOperation(id uuid.UUID, version int64) BatchOperation {
+	return BatchOperation{Op: "delete", ID: id.String(), Version: version}
+}
+
+type BatchRequest struct {
+	// BestEffort applies each operation independently instead of applying all
+	// of them or none.
+	BestEffort bool
+	Operations []BatchOperation
+}
+
+// BatchResult is the outcome of the operation at the same position in the
+// batch, Error is set if it failed.
+type BatchResult struct {
+	ID     uuid.UUID `json:"id"`
+	Status int       `json:"status"`
+	Error  *Problem  `json:"error,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is the RFC 7807 problem details returned by the API for a failed
+// request.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return fmt.Sprintf("%d %s: %s", p.Status, p.Title, p.Detail)
+	}
+	return fmt.Sprintf("%d %s", p.Status, p.Title)
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// New returns a client for the API served at baseURL, http.DefaultClient is
+// used if httpClient is nil.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+func (c *Client) Health(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/health", nil, nil, nil)
+	return err
+}
+
+func (c *Client) ListMovies(ctx context.Context, options ListMoviesOptions) (MoviesPage, error) {
+	query := url.Values{}
+	if options.Limit > 0 {
+		query.Set("limit", strconv.Itoa(options.Limit))
+	}
+	if options.Cursor != "" {
+		query.Set("cursor", options.Cursor)
+	}
+	if options.Sort != "" {
+		query.Set("sort", options.Sort)
+	}
+	if options.Director != "" {
+		query.Set("director", options.Director)
+	}
+	if options.ReleaseDateFrom != nil {
+		query.Set("release_date_from", options.ReleaseDateFrom.Format(time.RFC3339))
+	}
+	if options.ReleaseDateTo != nil {
+		query.Set("release_date_to", options.ReleaseDateTo.Format(time.RFC3339))
+	}
+	if options.MinTicketPrice != nil {
+		query.Set("min_ticket_price", strconv.FormatFloat(*options.MinTicketPrice, 'f', -1, 64))
+	}
+	if options.MaxTicketPrice != nil {
+		query.Set("max_ticket_price", strconv.FormatFloat(*options.MaxTicketPrice, 'f', -1, 64))
+	}
+
+	var page MoviesPage
+	header, err := c.do(ctx, http.MethodGet, "/api/movies?"+query.Encode(), nil, &page.Movies, nil)
+	if err != nil {
+		return MoviesPage{}, err
+	}
+
+	page.NextCursor, err = nextCursor(header.Get("Link"))
+	if err != nil {
+		return MoviesPage{}, err
+	}
+
+	return page, nil
+}
+
+// nextCursor returns the cursor of the rel="next" link, the API only sends a
+// Link header when there is a next page.
+func nextCursor(link string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+
+	target, _, _ := strings.Cut(link, ";")
+	target = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(target), "<"), ">")
+	next, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid Link header: %w", err)
+	}
+
+	return next.Query().Get("cursor"), nil
+}
+
+func (c *Client) SearchMovies(ctx context.Context, q string, limit int) ([]Movie, error) {
+	query := url.Values{"q": {q}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var movies []Movie
+	if _, err := c.do(ctx, http.MethodGet, "/api/movies/search?"+query.Encode(), nil, &movies, nil); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+func (c *Client) GetMovie(ctx context.Context, id uuid.UUID) (Movie, error) {
+	var movie Movie
+	if _, err := c.do(ctx, http.MethodGet, "/api/movies/"+id.String(), nil, &movie, nil); err != nil {
+		return Movie{}, err
+	}
+
+	return movie, nil
+}
+
+// CreateMovie creates a movie and returns its id, taken from the Location
+// header so it is known even when the request leaves ID empty.
+func (c *Client) CreateMovie(ctx context.Context, request CreateMovieRequest) (uuid.UUID, error) {
+	header, err := c.do(ctx, http.MethodPost, "/api/movies", request, nil, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id, err := uuid.Parse(path.Base(header.Get("Location")))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid Location header: %w", err)
+	}
+
+	return id, nil
+}
+
+func (c *Client) UpdateMovie(ctx context.Context, id uuid.UUID, request UpdateMovieRequest) error {
+	_, err := c.do(ctx, http.MethodPut, "/api/movies/"+id.String(), request, nil, ifMatch(request.Version))
+	return err
+}
+
+// PatchMovie changes the fields set in request and leaves the rest as is.
+func (c *Client) PatchMovie(ctx context.Context, id uuid.UUID, request PatchMovieRequest) error {
+	header := ifMatch(request.Version)
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/merge-patch+json")
+	_, err := c.do(ctx, http.MethodPatch, "/api/movies/"+id.String(), request, nil, header)
+	return err
+}
+
+// DeleteMovie deletes a movie, if version is not zero the delete fails when
+// the movie has been changed since.
+func (c *Client) DeleteMovie(ctx context.Context, id uuid.UUID, version int64) error {
+	_, err := c.do(ctx, http.MethodDelete, "/api/movies/"+id.String(), nil, nil, ifMatch(version))
+	return err
+}
+
+// BatchMovies applies a batch of writes, the error is only set if the batch as
+// a whole was rejected.
+func (c *Client) BatchMovies(ctx context.Context, request BatchRequest) ([]BatchResult, error) {
+	body := struct {
+		Mode       string           `json:"mode"`
+		Operations []BatchOperation `json:"operations"`
+	}{
+		Mode:       "atomic",
+		Operations: request.Operations,
+	}
+	if request.BestEffort {
+		body.Mode = "best_effort"
+	}
+
+	var response struct {
+		Results []BatchResult `json:"results"`
+	}
+	if _, err := c.do(ctx, http.MethodPost, "/api/movies:batch", body, &response, nil); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+func ifMatch(version int64) http.Header {
+	if version == 0 {
+		return nil
+	}
+	return http.Header{"If-Match": {`"` + strconv.FormatInt(version, 10) + `"`}}
+}
+
+// do sends a request with body encoded as JSON and decodes a successful
+// response into out, failed responses are returned as a *Problem.
+func (c *Client) do(ctx context.Context, method string, endpoint string, body any, out any, header http.Header) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		problem := &Problem{}
+		if err := json.NewDecoder(resp.Body).Decode(problem); err != nil || problem.Status == 0 {
+			problem = &Problem{Title: http.StatusText(resp.StatusCode), Status: resp.StatusCode}
+		}
+		return resp.Header, problem
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp.Header, nil
+}
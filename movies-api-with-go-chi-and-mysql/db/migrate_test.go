@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	source, err := iofs.New(migrations, "migrations")
+	require.NoError(t, err)
+	defer source.Close()
+
+	version, err := source.First()
+	require.NoError(t, err)
+	for {
+		for name, read := range map[string]func(uint) (io.ReadCloser, string, error){
+			"up":   source.ReadUp,
+			"down": source.ReadDown,
+		} {
+			r, _, err := read(version)
+			require.NoError(t, err, "version %d has no %s migration", version, name)
+			body, err := io.ReadAll(r)
+			r.Close()
+			require.NoError(t, err)
+			assert.NotEmpty(t, strings.TrimSpace(string(body)), "version %d has an empty %s migration", version, name)
+		}
+
+		version, err = source.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		require.NoError(t, err)
+	}
+}
+
+func TestIsLockError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"locked", database.ErrLocked, true},
+		{"lock timeout", migrate.ErrLockTimeout, true},
+		{"try lock failed", &database.Error{Err: "try lock failed with error -1: lock request timed out"}, true},
+		{"try lock connection error", &database.Error{OrigErr: errors.New("connection refused"), Err: "try lock failed"}, false},
+		{"migration failed", &database.Error{OrigErr: errors.New("syntax error"), Err: "migration failed"}, false},
+		{"no change", migrate.ErrNoChange, false},
+		{"nil", nil, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isLockError(tc.err))
+		})
+	}
+}
@@ -0,0 +1,3 @@
+ALTER TABLE Movies DROP INDEX IX_Movies_Title_Director;
+
+ALTER TABLE Movies DROP INDEX IX_Movies_Title;
@@ -0,0 +1,3 @@
+ALTER TABLE Movies ADD FULLTEXT INDEX IX_Movies_Title (Title);
+
+ALTER TABLE Movies ADD FULLTEXT INDEX IX_Movies_Title_Director (Title, Director);
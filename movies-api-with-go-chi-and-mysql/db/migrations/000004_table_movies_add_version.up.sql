@@ -0,0 +1 @@
+ALTER TABLE Movies ADD COLUMN Version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,31 @@
+package integrationtests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/db"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/store"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/store/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMySqlMoviesStore(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	if cfg.Database.DatabaseURL == "" {
+		t.Skip("database not configured: DATABASE_URL is not set")
+	}
+
+	err = db.Up(context.Background(), cfg.Database)
+	require.NoError(t, err)
+
+	sut, err := store.NewMySqlMoviesStore(context.Background(), cfg.Database)
+	require.NoError(t, err)
+	defer sut.Close()
+
+	storetest.Run(t, func(t *testing.T) store.Interface {
+		return sut
+	})
+}
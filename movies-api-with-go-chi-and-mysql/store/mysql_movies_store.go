@@ -3,57 +3,99 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/config"
 )
 
 const driverName = "mysql"
 
-type MySqlMoviesStore struct {
-	databaseUrl string
-	dbx         *sqlx.DB
+// MySqlDriver is the STORE_DRIVER name of MySqlMoviesStore.
+const MySqlDriver = "mysql"
+
+func init() {
+	Register(MySqlDriver, Driver{
+		Validate: validateMySqlConfig,
+		Open: func(ctx context.Context, config config.Database) (Closer, error) {
+			s, err := NewMySqlMoviesStore(ctx, config)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	})
 }
 
-func NewMySqlMoviesStore(databaseUrl string) *MySqlMoviesStore {
-	return &MySqlMoviesStore{
-		databaseUrl: databaseUrl,
+func validateMySqlConfig(config config.Database) error {
+	if err := requireDatabaseURL(config); err != nil {
+		return err
+	}
+	if _, err := mysql.ParseDSN(config.DatabaseURL); err != nil {
+		return fmt.Errorf("invalid DATABASE_URL: %w", err)
 	}
+	return nil
+}
+
+type MySqlMoviesStore struct {
+	db *sqlx.DB
+	// dbx is db, or the transaction the store is bound to by inTx
+	dbx sqlxExecutor
 }
 
 func noOpMapper(s string) string { return s }
 
-func (s *MySqlMoviesStore) connect(ctx context.Context) error {
-	dbx, err := sqlx.ConnectContext(ctx, driverName, s.databaseUrl)
+func NewMySqlMoviesStore(ctx context.Context, config config.Database) (*MySqlMoviesStore, error) {
+	mysqlConfig, err := mysql.ParseDSN(config.DatabaseURL)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// report matched rows instead of changed rows so an update that does not
+	// change any values can still be told apart from a missing record
+	mysqlConfig.ClientFoundRows = true
+
+	dbx, err := sqlx.ConnectContext(ctx, driverName, mysqlConfig.FormatDSN())
+	if err != nil {
+		return nil, err
 	}
 
 	dbx.MapperFunc(noOpMapper)
-	s.dbx = dbx
-	return nil
+	dbx.SetMaxOpenConns(config.MaxOpenConnections)
+	dbx.SetMaxIdleConns(config.MaxIdleConnections)
+	dbx.SetConnMaxLifetime(config.ConnectionMaxLifetime)
+	dbx.SetConnMaxIdleTime(config.ConnectionMaxIdleTime)
+
+	return &MySqlMoviesStore{
+		db:  dbx,
+		dbx: tracedExecutor{dbx},
+	}, nil
 }
 
-func (s *MySqlMoviesStore) close() error {
-	return s.dbx.Close()
+func (s *MySqlMoviesStore) Close() error {
+	return s.db.Close()
 }
 
-func (s *MySqlMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
-	err := s.connect(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer s.close()
+// DB returns the connection pool of the store, e.g. to collect its stats.
+func (s *MySqlMoviesStore) DB() *sql.DB {
+	return s.db.DB
+}
 
+func (s *MySqlMoviesStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *MySqlMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
 	var movies []Movie
 	if err := s.dbx.SelectContext(
 		ctx,
 		&movies,
 		`SELECT
-			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt
+			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version
 		FROM Movies`); err != nil {
 		return nil, err
 	}
@@ -61,19 +103,102 @@ func (s *MySqlMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
 	return movies, nil
 }
 
-func (s *MySqlMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
-	err := s.connect(ctx)
+var mySqlMovieColumns = movieColumns{
+	ID:          "Id",
+	SortID:      "Id",
+	Title:       "Title",
+	SortTitle:   "CONVERT(Title USING utf8mb4) COLLATE utf8mb4_bin",
+	Director:    "Director",
+	ReleaseDate: "ReleaseDate",
+	TicketPrice: "TicketPrice",
+	CreatedAt:   "CreatedAt",
+	UpdatedAt:   "UpdatedAt",
+	Version:     "Version",
+}
+
+func (s *MySqlMoviesStore) List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error) {
+	where, orderBy, args, err := buildListMoviesQuery(mySqlMovieColumns, listMoviesParams)
 	if err != nil {
-		return Movie{}, err
+		return MoviesPage{}, err
+	}
+
+	limit := ""
+	if listMoviesParams.Limit > 0 {
+		limit = "LIMIT :limit"
+		args["limit"] = listMoviesParams.Limit + 1
 	}
-	defer s.close()
 
+	query, queryArgs, err := s.dbx.BindNamed(
+		`SELECT
+			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version
+		FROM Movies
+		`+where+`
+		`+orderBy+`
+		`+limit,
+		args)
+	if err != nil {
+		return MoviesPage{}, err
+	}
+
+	var movies []Movie
+	if err := s.dbx.SelectContext(ctx, &movies, query, queryArgs...); err != nil {
+		return MoviesPage{}, err
+	}
+
+	return nextPage(movies, listMoviesParams.Limit), nil
+}
+
+// Search matches the search terms as prefixes using the FULLTEXT indexes in
+// boolean mode, title matches are weighted twice as much as director matches.
+func (s *MySqlMoviesStore) Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error) {
+	terms, err := parseSearchTerms(searchMoviesParams.Query)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	for i, term := range terms {
+		terms[i] = "+" + term + "*"
+	}
+	args := map[string]any{"query": strings.Join(terms, " ")}
+
+	limit := ""
+	if searchMoviesParams.Limit > 0 {
+		limit = "LIMIT :limit"
+		args["limit"] = searchMoviesParams.Limit
+	}
+
+	query, queryArgs, err := s.dbx.BindNamed(
+		`SELECT
+			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version
+		FROM Movies
+		WHERE MATCH (Title, Director) AGAINST (:query IN BOOLEAN MODE)
+		ORDER BY
+			2 * MATCH (Title) AGAINST (:query IN BOOLEAN MODE) + MATCH (Title, Director) AGAINST (:query IN BOOLEAN MODE) DESC,
+			Title, CreatedAt, Id
+		`+limit,
+		args)
+	if err != nil {
+		return nil, err
+	}
+
+	var movies []Movie
+	if err := s.dbx.SelectContext(ctx, &movies, query, queryArgs...); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+func (s *MySqlMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
 	var movie Movie
 	if err := s.dbx.GetContext(
 		ctx,
 		&movie,
 		`SELECT
-			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt
+			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version
 		FROM Movies
 		WHERE Id = ?`,
 		id); err != nil {
@@ -87,13 +212,17 @@ func (s *MySqlMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, er
 	return movie, nil
 }
 
-func (s *MySqlMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
-	err := s.connect(ctx)
-	if err != nil {
-		return err
-	}
-	defer s.close()
+const (
+	insertMovieQuery = `INSERT INTO Movies
+			(Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version)
+		VALUES
+			(:Id, :Title, :Director, :ReleaseDate, :TicketPrice, :CreatedAt, :UpdatedAt, :Version)`
+	// movies per INSERT in CreateMany, each takes 8 of the 65535 placeholders
+	// mysql allows in a statement
+	createManyBatchSize = 1000
+)
 
+func (s *MySqlMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
 	movie := Movie{
 		ID:          createMovieParams.ID,
 		Title:       createMovieParams.Title,
@@ -102,15 +231,10 @@ func (s *MySqlMoviesStore) Create(ctx context.Context, createMovieParams CreateM
 		TicketPrice: createMovieParams.TicketPrice,
 		CreatedAt:   time.Now().UTC(),
 		UpdatedAt:   time.Now().UTC(),
+		Version:     1,
 	}
 
-	if _, err := s.dbx.NamedExecContext(
-		ctx,
-		`INSERT INTO Movies
-			(Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt)
-		VALUES
-			(:Id, :Title, :Director, :ReleaseDate, :TicketPrice, :CreatedAt, :UpdatedAt)`,
-		movie); err != nil {
+	if _, err := s.dbx.NamedExecContext(ctx, insertMovieQuery, movie); err != nil {
 		if strings.Contains(err.Error(), "Error 1062") {
 			return &DuplicateKeyError{ID: createMovieParams.ID}
 		}
@@ -120,13 +244,48 @@ func (s *MySqlMoviesStore) Create(ctx context.Context, createMovieParams CreateM
 	return nil
 }
 
-func (s *MySqlMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
-	err := s.connect(ctx)
-	if err != nil {
-		return err
+func (s *MySqlMoviesStore) CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error {
+	if id, ok := duplicateMovieID(createMoviesParams); ok {
+		return &DuplicateKeyError{ID: id}
+	}
+
+	now := time.Now().UTC()
+	movies := make([]Movie, 0, len(createMoviesParams))
+	for _, p := range createMoviesParams {
+		movies = append(movies, Movie{
+			ID:          p.ID,
+			Title:       p.Title,
+			Director:    p.Director,
+			ReleaseDate: p.ReleaseDate,
+			TicketPrice: p.TicketPrice,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			Version:     1,
+		})
 	}
-	defer s.close()
 
+	return s.inTx(ctx, func(tx *MySqlMoviesStore) error {
+		for start := 0; start < len(movies); start += createManyBatchSize {
+			end := start + createManyBatchSize
+			if end > len(movies) {
+				end = len(movies)
+			}
+
+			if _, err := tx.dbx.NamedExecContext(ctx, insertMovieQuery, movies[start:end]); err != nil {
+				var mysqlErr *mysql.MySQLError
+				if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+					if id, ok := reportedMovieID(mysqlErr.Message, createMoviesParams); ok {
+						return &DuplicateKeyError{ID: id}
+					}
+				}
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *MySqlMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
 	movie := Movie{
 		ID:          id,
 		Title:       updateMovieParams.Title,
@@ -134,33 +293,133 @@ func (s *MySqlMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovie
 		ReleaseDate: updateMovieParams.ReleaseDate,
 		TicketPrice: updateMovieParams.TicketPrice,
 		UpdatedAt:   time.Now().UTC(),
+		Version:     updateMovieParams.ExpectedVersion,
 	}
 
-	if _, err := s.dbx.NamedExecContext(
-		ctx,
-		`UPDATE Movies
-		SET Title = :Title, Director = :Director, ReleaseDate = :ReleaseDate, TicketPrice = :TicketPrice, UpdatedAt = :UpdatedAt
-		WHERE Id = :Id`,
-		movie); err != nil {
+	query := `UPDATE Movies
+		SET Title = :Title, Director = :Director, ReleaseDate = :ReleaseDate, TicketPrice = :TicketPrice, UpdatedAt = :UpdatedAt, Version = Version + 1
+		WHERE Id = :Id`
+	if updateMovieParams.ExpectedVersion > 0 {
+		query += ` AND Version = :Version`
+	}
+
+	result, err := s.dbx.NamedExecContext(ctx, query, movie)
+	if err != nil {
 		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, updateMovieParams.ExpectedVersion)
+	}
+
 	return nil
 }
 
-func (s *MySqlMoviesStore) Delete(ctx context.Context, id uuid.UUID) error {
-	err := s.connect(ctx)
+func (s *MySqlMoviesStore) Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error {
+	set, where, args := buildPatchMovieQuery(mySqlMovieColumns, id, patchMovieParams, time.Now().UTC())
+	query := `UPDATE Movies
+		` + set + `
+		` + where
+
+	result, err := s.dbx.NamedExecContext(ctx, query, args)
 	if err != nil {
 		return err
 	}
-	defer s.close()
 
-	if _, err := s.dbx.ExecContext(
-		ctx,
-		`DELETE FROM Movies
-		WHERE id = ?`, id); err != nil {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, patchMovieParams.ExpectedVersion)
+	}
+
+	return nil
+}
+
+func (s *MySqlMoviesStore) Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error {
+	query := `DELETE FROM Movies
+		WHERE Id = ?`
+	args := []any{id}
+	if deleteMovieParams.ExpectedVersion > 0 {
+		query += ` AND Version = ?`
+		args = append(args, deleteMovieParams.ExpectedVersion)
+	}
+
+	result, err := s.dbx.ExecContext(ctx, query, args...)
+	if err != nil {
 		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, deleteMovieParams.ExpectedVersion)
+	}
+
 	return nil
 }
+
+func (s *MySqlMoviesStore) Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error) {
+	return batch(ctx, s, operations, atomic)
+}
+
+func (s *MySqlMoviesStore) WithTx(ctx context.Context, fn func(tx Interface) error) error {
+	return s.inTx(ctx, func(tx *MySqlMoviesStore) error {
+		return fn(tx)
+	})
+}
+
+// inTx runs fn with a copy of the store bound to a transaction, which is
+// committed if fn succeeds. A store already bound to one reuses it.
+func (s *MySqlMoviesStore) inTx(ctx context.Context, fn func(tx *MySqlMoviesStore) error) error {
+	if boundToTx(s.dbx) {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&MySqlMoviesStore{db: s.db, dbx: tracedExecutor{tx}}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// noRowsAffectedError tells apart a missing movie from a version mismatch
+// when a conditional write did not affect any rows.
+func (s *MySqlMoviesStore) noRowsAffectedError(ctx context.Context, id uuid.UUID, expectedVersion int64) error {
+	if expectedVersion == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	var count int
+	if err := s.dbx.GetContext(
+		ctx,
+		&count,
+		`SELECT COUNT(*) FROM Movies
+		WHERE Id = ?`,
+		id); err != nil {
+		return err
+	}
+	if count == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	return &VersionMismatchError{ID: id, ExpectedVersion: expectedVersion}
+}
@@ -0,0 +1,41 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	t.Run("given memory driver, should open memory store", func(t *testing.T) {
+		sut, err := store.Open(context.Background(), config.Database{Driver: store.MemoryDriver})
+
+		require.NoError(t, err)
+		defer sut.Close()
+		movies, err := sut.GetAll(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, movies)
+	})
+
+	t.Run("given unknown driver, should list registered drivers", func(t *testing.T) {
+		_, err := store.Open(context.Background(), config.Database{Driver: "oracle"})
+
+		assert.EqualError(t, err, `unknown STORE_DRIVER "oracle", registered drivers are memory, mysql`)
+	})
+
+	t.Run("given database driver without database url, should return error", func(t *testing.T) {
+		_, err := store.Open(context.Background(), config.Database{Driver: store.MySqlDriver})
+
+		assert.EqualError(t, err, "store driver mysql: DATABASE_URL is required")
+	})
+
+	t.Run("given invalid database url, should return error", func(t *testing.T) {
+		err := store.Validate(config.Database{Driver: store.MySqlDriver, DatabaseURL: "root:secret@tcp(localhost:3306)"})
+
+		assert.ErrorContains(t, err, "store driver mysql: invalid DATABASE_URL")
+	})
+}
@@ -0,0 +1,1032 @@
+// Package storetest provides conformance tests that every store.Interface
+// implementation is expected to pass.
+//
+// The tests only rely on records they create and remove them when done, so
+// they can run against a shared database that already contains movies.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-mysql/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// timestampTolerance allows for stores that round timestamps to whole
+	// seconds and for clock drift between the test and the database server.
+	timestampTolerance = 2 * time.Second
+	// searchTimeout allows for stores that populate their search index
+	// asynchronously.
+	searchTimeout = 10 * time.Second
+	concurrency   = 10
+)
+
+// Factory returns the store under test, it is called once per test.
+type Factory func(t *testing.T) store.Interface
+
+// Run runs the conformance tests against the stores returned by newStore.
+func Run(t *testing.T, newStore Factory) {
+	t.Run("GetAll", func(t *testing.T) { testGetAll(t, newStore(t)) })
+	t.Run("GetByID", func(t *testing.T) { testGetByID(t, newStore(t)) })
+	t.Run("Create", func(t *testing.T) { testCreate(t, newStore(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, newStore(t)) })
+	t.Run("Patch", func(t *testing.T) { testPatch(t, newStore(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStore(t)) })
+	t.Run("CreateMany", func(t *testing.T) { testCreateMany(t, newStore(t)) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, newStore(t)) })
+	t.Run("WithTx", func(t *testing.T) { testWithTx(t, newStore(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, newStore(t)) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, newStore(t)) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, newStore(t)) })
+	t.Run("Ping", func(t *testing.T) { testPing(t, newStore(t)) })
+}
+
+func newCreateMovieParams() store.CreateMovieParams {
+	return store.CreateMovieParams{
+		ID:          uuid.New(),
+		Title:       "Conformance",
+		Director:    "Storetest",
+		ReleaseDate: time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 12.5,
+	}
+}
+
+// uniqueWord returns a word no other movie contains, it is used to scope
+// filters and searches to the movies created by a test.
+func uniqueWord() string {
+	return "st" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+func createMovie(t *testing.T, sut store.Interface, p store.CreateMovieParams) store.Movie {
+	t.Helper()
+
+	require.NoError(t, sut.Create(context.Background(), p))
+	t.Cleanup(func() {
+		sut.Delete(context.Background(), p.ID, store.DeleteMovieParams{})
+	})
+
+	m, err := sut.GetByID(context.Background(), p.ID)
+	require.NoError(t, err)
+
+	return m
+}
+
+func assertMovie(t *testing.T, expected store.CreateMovieParams, actual store.Movie) {
+	t.Helper()
+
+	assert.Equal(t, expected.ID, actual.ID)
+	assert.Equal(t, expected.Title, actual.Title)
+	assert.Equal(t, expected.Director, actual.Director)
+	assert.True(t, expected.ReleaseDate.Equal(actual.ReleaseDate), "expected release date %v, got %v", expected.ReleaseDate, actual.ReleaseDate)
+	assert.Equal(t, expected.TicketPrice, actual.TicketPrice)
+}
+
+func movieIDs(movies []store.Movie) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, m := range movies {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func testGetAll(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	t.Run("given records exist, should return all records", func(t *testing.T) {
+		m1 := createMovie(t, sut, newCreateMovieParams())
+		m2 := createMovie(t, sut, newCreateMovieParams())
+
+		movies, err := sut.GetAll(ctx)
+
+		require.NoError(t, err)
+		assert.Subset(t, movieIDs(movies), []uuid.UUID{m1.ID, m2.ID})
+	})
+}
+
+func testGetByID(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	t.Run("given record does not exist, should return RecordNotFoundError", func(t *testing.T) {
+		_, err := sut.GetByID(ctx, uuid.New())
+
+		var targetErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+
+	t.Run("given record exists, should return record", func(t *testing.T) {
+		p := newCreateMovieParams()
+		createMovie(t, sut, p)
+
+		m, err := sut.GetByID(ctx, p.ID)
+
+		require.NoError(t, err)
+		assertMovie(t, p, m)
+	})
+}
+
+func testCreate(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	t.Run("given record does not exist, should create record", func(t *testing.T) {
+		p := newCreateMovieParams()
+		start := time.Now()
+
+		m := createMovie(t, sut, p)
+
+		assertMovie(t, p, m)
+		assert.Equal(t, int64(1), m.Version)
+		assert.WithinDuration(t, start, m.CreatedAt, timestampTolerance)
+		assert.WithinDuration(t, start, m.UpdatedAt, timestampTolerance)
+	})
+
+	t.Run("given record with id exists, should return DuplicateKeyError", func(t *testing.T) {
+		p := newCreateMovieParams()
+		createMovie(t, sut, p)
+
+		err := sut.Create(ctx, p)
+
+		var targetErr *store.DuplicateKeyError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, p.ID, targetErr.ID)
+	})
+
+	t.Run("given ticket price with cents, should keep precision", func(t *testing.T) {
+		for _, ticketPrice := range []float64{0.01, 0.1, 12.34, 19.99, 99999999.99} {
+			p := newCreateMovieParams()
+			p.TicketPrice = ticketPrice
+
+			m := createMovie(t, sut, p)
+
+			assert.Equal(t, ticketPrice, m.TicketPrice)
+		}
+	})
+}
+
+func testUpdate(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	t.Run("given record does not exist, should return RecordNotFoundError", func(t *testing.T) {
+		err := sut.Update(ctx, uuid.New(), store.UpdateMovieParams{
+			Title:       "Missing",
+			Director:    "Storetest",
+			ReleaseDate: time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC),
+			TicketPrice: 10,
+		})
+
+		var targetErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+
+	t.Run("given record exists, should update record", func(t *testing.T) {
+		created := createMovie(t, sut, newCreateMovieParams())
+		p := store.UpdateMovieParams{
+			Title:       "Updated",
+			Director:    "Storetest Updated",
+			ReleaseDate: time.Date(2002, time.February, 2, 0, 0, 0, 0, time.UTC),
+			TicketPrice: 15.75,
+		}
+
+		err := sut.Update(ctx, created.ID, p)
+		require.NoError(t, err)
+
+		m, err := sut.GetByID(ctx, created.ID)
+		require.NoError(t, err)
+		assertMovie(t, store.CreateMovieParams{
+			ID:          created.ID,
+			Title:       p.Title,
+			Director:    p.Director,
+			ReleaseDate: p.ReleaseDate,
+			TicketPrice: p.TicketPrice,
+		}, m)
+		assert.True(t, created.CreatedAt.Equal(m.CreatedAt), "expected created at %v, got %v", created.CreatedAt, m.CreatedAt)
+		assert.False(t, m.UpdatedAt.Before(created.UpdatedAt), "expected updated at %v not to be before %v", m.UpdatedAt, created.UpdatedAt)
+		assert.Equal(t, created.Version+1, m.Version)
+	})
+
+	t.Run("given expected version matches, should update record", func(t *testing.T) {
+		created := createMovie(t, sut, newCreateMovieParams())
+
+		err := sut.Update(ctx, created.ID, store.UpdateMovieParams{
+			Title:           "Updated",
+			Director:        created.Director,
+			ReleaseDate:     created.ReleaseDate,
+			TicketPrice:     created.TicketPrice,
+			ExpectedVersion: created.Version,
+		})
+		require.NoError(t, err)
+
+		m, err := sut.GetByID(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Updated", m.Title)
+		assert.Equal(t, created.Version+1, m.Version)
+	})
+
+	t.Run("given expected version is stale, should return VersionMismatchError", func(t *testing.T) {
+		created := createMovie(t, sut, newCreateMovieParams())
+		p := store.UpdateMovieParams{
+			Title:           "Updated",
+			Director:        created.Director,
+			ReleaseDate:     created.ReleaseDate,
+			TicketPrice:     created.TicketPrice,
+			ExpectedVersion: created.Version,
+		}
+		require.NoError(t, sut.Update(ctx, created.ID, p))
+
+		p.Title = "Stale"
+		err := sut.Update(ctx, created.ID, p)
+
+		var targetErr *store.VersionMismatchError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, created.ID, targetErr.ID)
+		assert.Equal(t, created.Version, targetErr.ExpectedVersion)
+
+		m, err := sut.GetByID(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Updated", m.Title)
+	})
+
+	t.Run("given expected version and record does not exist, should return RecordNotFoundError", func(t *testing.T) {
+		err := sut.Update(ctx, uuid.New(), store.UpdateMovieParams{
+			Title:           "Missing",
+			Director:        "Storetest",
+			ReleaseDate:     time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC),
+			TicketPrice:     10,
+			ExpectedVersion: 1,
+		})
+
+		var targetErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+}
+
+func testPatch(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	t.Run("given record does not exist, should return RecordNotFoundError", func(t *testing.T) {
+		title := "Missing"
+
+		err := sut.Patch(ctx, uuid.New(), store.PatchMovieParams{Title: &title})
+
+		var targetErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+
+	t.Run("given some fields, should only update those fields", func(t *testing.T) {
+		p := newCreateMovieParams()
+		created := createMovie(t, sut, p)
+		title := "Patched"
+		ticketPrice := 7.25
+
+		err := sut.Patch(ctx, created.ID, store.PatchMovieParams{Title: &title, TicketPrice: &ticketPrice})
+		require.NoError(t, err)
+
+		m, err := sut.GetByID(ctx, created.ID)
+		require.NoError(t, err)
+		p.Title = title
+		p.TicketPrice = ticketPrice
+		assertMovie(t, p, m)
+		assert.True(t, created.CreatedAt.Equal(m.CreatedAt), "expected created at %v, got %v", created.CreatedAt, m.CreatedAt)
+		assert.Equal(t, created.Version+1, m.Version)
+	})
+
+	t.Run("given other fields, should only update those fields", func(t *testing.T) {
+		p := newCreateMovieParams()
+		created := createMovie(t, sut, p)
+		director := "Storetest Patched"
+		releaseDate := time.Date(2005, time.May, 5, 0, 0, 0, 0, time.UTC)
+
+		err := sut.Patch(ctx, created.ID, store.PatchMovieParams{Director: &director, ReleaseDate: &releaseDate})
+		require.NoError(t, err)
+
+		m, err := sut.GetByID(ctx, created.ID)
+		require.NoError(t, err)
+		p.Director = director
+		p.ReleaseDate = releaseDate
+		assertMovie(t, p, m)
+	})
+
+	t.Run("given expected version is stale, should return VersionMismatchError", func(t *testing.T) {
+		created := createMovie(t, sut, newCreateMovieParams())
+		title := "Patched"
+		require.NoError(t, sut.Patch(ctx, created.ID, store.PatchMovieParams{Title: &title, ExpectedVersion: created.Version}))
+
+		stale := "Stale"
+		err := sut.Patch(ctx, created.ID, store.PatchMovieParams{Title: &stale, ExpectedVersion: created.Version})
+
+		var targetErr *store.VersionMismatchError
+		require.ErrorAs(t, err, &targetErr)
+
+		m, err := sut.GetByID(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, title, m.Title)
+		assert.Equal(t, created.Version+1, m.Version)
+	})
+
+	t.Run("given patched title, should search by new title", func(t *testing.T) {
+		created := createMovie(t, sut, newCreateMovieParams())
+		word := uniqueWord()
+
+		err := sut.Patch(ctx, created.ID, store.PatchMovieParams{Title: &word})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			movies, err := sut.Search(ctx, store.SearchMoviesParams{Query: word})
+			return err == nil && len(movies) == 1 && movies[0].ID == created.ID
+		}, searchTimeout, 100*time.Millisecond, "expected search index to contain patched title")
+	})
+}
+
+func testDelete(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	t.Run("given record does not exist, should return RecordNotFoundError", func(t *testing.T) {
+		err := sut.Delete(ctx, uuid.New(), store.DeleteMovieParams{})
+
+		var targetErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+
+	t.Run("given record exists, should delete record", func(t *testing.T) {
+		m := createMovie(t, sut, newCreateMovieParams())
+
+		err := sut.Delete(ctx, m.ID, store.DeleteMovieParams{})
+		require.NoError(t, err)
+
+		_, err = sut.GetByID(ctx, m.ID)
+		var targetErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &targetErr)
+
+		err = sut.Delete(ctx, m.ID, store.DeleteMovieParams{})
+		assert.ErrorAs(t, err, &targetErr)
+	})
+
+	t.Run("given expected version matches, should delete record", func(t *testing.T) {
+		m := createMovie(t, sut, newCreateMovieParams())
+
+		err := sut.Delete(ctx, m.ID, store.DeleteMovieParams{ExpectedVersion: m.Version})
+		require.NoError(t, err)
+
+		_, err = sut.GetByID(ctx, m.ID)
+		var targetErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+
+	t.Run("given expected version is stale, should return VersionMismatchError", func(t *testing.T) {
+		m := createMovie(t, sut, newCreateMovieParams())
+
+		err := sut.Delete(ctx, m.ID, store.DeleteMovieParams{ExpectedVersion: m.Version + 1})
+
+		var targetErr *store.VersionMismatchError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, m.Version+1, targetErr.ExpectedVersion)
+
+		_, err = sut.GetByID(ctx, m.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("given expected version and record does not exist, should return RecordNotFoundError", func(t *testing.T) {
+		err := sut.Delete(ctx, uuid.New(), store.DeleteMovieParams{ExpectedVersion: 1})
+
+		var targetErr *store.RecordNotFoundError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+}
+
+// deleteOnCleanup removes movies created by a test through CreateMany or Batch.
+func deleteOnCleanup(t *testing.T, sut store.Interface, ids ...uuid.UUID) {
+	t.Cleanup(func() {
+		for _, id := range ids {
+			sut.Delete(context.Background(), id, store.DeleteMovieParams{})
+		}
+	})
+}
+
+func requireNotExists(t *testing.T, sut store.Interface, id uuid.UUID) {
+	t.Helper()
+
+	_, err := sut.GetByID(context.Background(), id)
+	var targetErr *store.RecordNotFoundError
+	require.ErrorAs(t, err, &targetErr)
+}
+
+func testCreateMany(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	t.Run("given records do not exist, should create all records", func(t *testing.T) {
+		ps := []store.CreateMovieParams{newCreateMovieParams(), newCreateMovieParams(), newCreateMovieParams()}
+		deleteOnCleanup(t, sut, ps[0].ID, ps[1].ID, ps[2].ID)
+
+		err := sut.CreateMany(ctx, ps)
+		require.NoError(t, err)
+
+		for _, p := range ps {
+			m, err := sut.GetByID(ctx, p.ID)
+			require.NoError(t, err)
+			assertMovie(t, p, m)
+			assert.Equal(t, int64(1), m.Version)
+		}
+	})
+
+	t.Run("given a record exists, should return DuplicateKeyError and create none", func(t *testing.T) {
+		existing := createMovie(t, sut, newCreateMovieParams())
+		ps := []store.CreateMovieParams{newCreateMovieParams(), newCreateMovieParams(), newCreateMovieParams()}
+		ps[1].ID = existing.ID
+		deleteOnCleanup(t, sut, ps[0].ID, ps[2].ID)
+
+		err := sut.CreateMany(ctx, ps)
+
+		var targetErr *store.DuplicateKeyError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, existing.ID, targetErr.ID)
+		requireNotExists(t, sut, ps[0].ID)
+		requireNotExists(t, sut, ps[2].ID)
+	})
+
+	t.Run("given an id is repeated, should return DuplicateKeyError and create none", func(t *testing.T) {
+		ps := []store.CreateMovieParams{newCreateMovieParams(), newCreateMovieParams(), newCreateMovieParams()}
+		ps[2].ID = ps[1].ID
+		deleteOnCleanup(t, sut, ps[0].ID, ps[1].ID)
+
+		err := sut.CreateMany(ctx, ps)
+
+		var targetErr *store.DuplicateKeyError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, ps[1].ID, targetErr.ID)
+		requireNotExists(t, sut, ps[0].ID)
+		requireNotExists(t, sut, ps[1].ID)
+	})
+}
+
+func testBatch(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+	updateMovieParams := store.UpdateMovieParams{
+		Title:       "Conformance Batch",
+		Director:    "Storetest Batch",
+		ReleaseDate: time.Date(2002, time.February, 2, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 15.75,
+	}
+
+	for _, atomic := range []bool{true, false} {
+		name := "best effort"
+		if atomic {
+			name = "atomic"
+		}
+
+		t.Run("given "+name+" batch succeeds, should apply every operation", func(t *testing.T) {
+			toUpdate := createMovie(t, sut, newCreateMovieParams())
+			toDelete := createMovie(t, sut, newCreateMovieParams())
+			created1, created2 := newCreateMovieParams(), newCreateMovieParams()
+			deleteOnCleanup(t, sut, created1.ID, created2.ID)
+
+			results, err := sut.Batch(ctx, []store.BatchOperation{
+				{Type: store.BatchCreate, Create: created1},
+				{Type: store.BatchCreate, Create: created2},
+				{Type: store.BatchUpdate, ID: toUpdate.ID, Update: updateMovieParams},
+				{Type: store.BatchDelete, ID: toDelete.ID},
+			}, atomic)
+
+			require.NoError(t, err)
+			assert.Equal(t, []error{nil, nil, nil, nil}, results)
+			for _, p := range []store.CreateMovieParams{created1, created2} {
+				m, err := sut.GetByID(ctx, p.ID)
+				require.NoError(t, err)
+				assertMovie(t, p, m)
+			}
+			m, err := sut.GetByID(ctx, toUpdate.ID)
+			require.NoError(t, err)
+			assert.Equal(t, updateMovieParams.Title, m.Title)
+			assert.Equal(t, toUpdate.Version+1, m.Version)
+			requireNotExists(t, sut, toDelete.ID)
+		})
+	}
+
+	t.Run("given atomic batch fails, should roll back every operation", func(t *testing.T) {
+		toUpdate := createMovie(t, sut, newCreateMovieParams())
+		created1, created2 := newCreateMovieParams(), newCreateMovieParams()
+		deleteOnCleanup(t, sut, created1.ID, created2.ID)
+		missing := uuid.New()
+
+		results, err := sut.Batch(ctx, []store.BatchOperation{
+			{Type: store.BatchCreate, Create: created1},
+			{Type: store.BatchCreate, Create: created2},
+			{Type: store.BatchUpdate, ID: toUpdate.ID, Update: updateMovieParams},
+			{Type: store.BatchDelete, ID: missing},
+			{Type: store.BatchDelete, ID: toUpdate.ID},
+		}, true)
+
+		require.NoError(t, err)
+		require.Len(t, results, 5)
+		var notFoundErr *store.RecordNotFoundError
+		assert.ErrorAs(t, results[3], &notFoundErr)
+		for _, i := range []int{0, 1, 2, 4} {
+			var abortedErr *store.BatchAbortedError
+			if assert.ErrorAs(t, results[i], &abortedErr) {
+				assert.Equal(t, 3, abortedErr.FailedIndex)
+			}
+		}
+		requireNotExists(t, sut, created1.ID)
+		requireNotExists(t, sut, created2.ID)
+		m, err := sut.GetByID(ctx, toUpdate.ID)
+		require.NoError(t, err)
+		assertMovie(t, store.CreateMovieParams{
+			ID:          toUpdate.ID,
+			Title:       toUpdate.Title,
+			Director:    toUpdate.Director,
+			ReleaseDate: toUpdate.ReleaseDate,
+			TicketPrice: toUpdate.TicketPrice,
+		}, m)
+		assert.Equal(t, toUpdate.Version, m.Version)
+	})
+
+	t.Run("given atomic batch creates a duplicate, should report the duplicate create", func(t *testing.T) {
+		existing := createMovie(t, sut, newCreateMovieParams())
+		created1, created2 := newCreateMovieParams(), newCreateMovieParams()
+		duplicate := newCreateMovieParams()
+		duplicate.ID = existing.ID
+		deleteOnCleanup(t, sut, created1.ID, created2.ID)
+
+		results, err := sut.Batch(ctx, []store.BatchOperation{
+			{Type: store.BatchCreate, Create: created1},
+			{Type: store.BatchCreate, Create: duplicate},
+			{Type: store.BatchCreate, Create: created2},
+		}, true)
+
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		var duplicateKeyErr *store.DuplicateKeyError
+		require.ErrorAs(t, results[1], &duplicateKeyErr)
+		assert.Equal(t, existing.ID, duplicateKeyErr.ID)
+		var abortedErr *store.BatchAbortedError
+		assert.ErrorAs(t, results[0], &abortedErr)
+		assert.ErrorAs(t, results[2], &abortedErr)
+		requireNotExists(t, sut, created1.ID)
+		requireNotExists(t, sut, created2.ID)
+	})
+
+	t.Run("given best effort batch fails, should apply the other operations", func(t *testing.T) {
+		toDelete := createMovie(t, sut, newCreateMovieParams())
+		existing := createMovie(t, sut, newCreateMovieParams())
+		created := newCreateMovieParams()
+		duplicate := newCreateMovieParams()
+		duplicate.ID = existing.ID
+		deleteOnCleanup(t, sut, created.ID)
+
+		results, err := sut.Batch(ctx, []store.BatchOperation{
+			{Type: store.BatchCreate, Create: duplicate},
+			{Type: store.BatchCreate, Create: created},
+			{Type: store.BatchUpdate, ID: existing.ID, Update: store.UpdateMovieParams{
+				Title:           updateMovieParams.Title,
+				Director:        updateMovieParams.Director,
+				ReleaseDate:     updateMovieParams.ReleaseDate,
+				TicketPrice:     updateMovieParams.TicketPrice,
+				ExpectedVersion: existing.Version + 1,
+			}},
+			{Type: store.BatchDelete, ID: toDelete.ID},
+		}, false)
+
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+		var duplicateKeyErr *store.DuplicateKeyError
+		assert.ErrorAs(t, results[0], &duplicateKeyErr)
+		assert.NoError(t, results[1])
+		var versionMismatchErr *store.VersionMismatchError
+		assert.ErrorAs(t, results[2], &versionMismatchErr)
+		assert.NoError(t, results[3])
+
+		_, err = sut.GetByID(ctx, created.ID)
+		assert.NoError(t, err)
+		requireNotExists(t, sut, toDelete.ID)
+	})
+}
+
+func testWithTx(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+	updateMovieParams := store.UpdateMovieParams{
+		Title:       "Conformance Tx",
+		Director:    "Storetest Tx",
+		ReleaseDate: time.Date(2002, time.February, 2, 0, 0, 0, 0, time.UTC),
+		TicketPrice: 15.75,
+	}
+
+	t.Run("given fn succeeds, should commit every change", func(t *testing.T) {
+		toUpdate := createMovie(t, sut, newCreateMovieParams())
+		toDelete := createMovie(t, sut, newCreateMovieParams())
+		p := newCreateMovieParams()
+		deleteOnCleanup(t, sut, p.ID)
+
+		err := sut.WithTx(ctx, func(tx store.Interface) error {
+			if err := tx.Create(ctx, p); err != nil {
+				return err
+			}
+			if err := tx.Update(ctx, toUpdate.ID, updateMovieParams); err != nil {
+				return err
+			}
+			return tx.Delete(ctx, toDelete.ID, store.DeleteMovieParams{})
+		})
+		require.NoError(t, err)
+
+		m, err := sut.GetByID(ctx, p.ID)
+		require.NoError(t, err)
+		assertMovie(t, p, m)
+		m, err = sut.GetByID(ctx, toUpdate.ID)
+		require.NoError(t, err)
+		assert.Equal(t, updateMovieParams.Title, m.Title)
+		requireNotExists(t, sut, toDelete.ID)
+	})
+
+	t.Run("given fn fails, should roll back every change and return its error", func(t *testing.T) {
+		toUpdate := createMovie(t, sut, newCreateMovieParams())
+		p := newCreateMovieParams()
+		deleteOnCleanup(t, sut, p.ID)
+		fnErr := errors.New("fn failed")
+
+		err := sut.WithTx(ctx, func(tx store.Interface) error {
+			if err := tx.Create(ctx, p); err != nil {
+				return err
+			}
+			if err := tx.Update(ctx, toUpdate.ID, updateMovieParams); err != nil {
+				return err
+			}
+			return fnErr
+		})
+		require.ErrorIs(t, err, fnErr)
+
+		requireNotExists(t, sut, p.ID)
+		m, err := sut.GetByID(ctx, toUpdate.ID)
+		require.NoError(t, err)
+		assert.Equal(t, toUpdate.Title, m.Title)
+		assert.Equal(t, toUpdate.Version, m.Version)
+	})
+
+	t.Run("given changes in tx, should read them within tx", func(t *testing.T) {
+		p := newCreateMovieParams()
+		deleteOnCleanup(t, sut, p.ID)
+
+		err := sut.WithTx(ctx, func(tx store.Interface) error {
+			if err := tx.Create(ctx, p); err != nil {
+				return err
+			}
+			if err := tx.Update(ctx, p.ID, updateMovieParams); err != nil {
+				return err
+			}
+
+			m, err := tx.GetByID(ctx, p.ID)
+			require.NoError(t, err)
+			assert.Equal(t, updateMovieParams.Title, m.Title)
+			assert.Equal(t, int64(2), m.Version)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("given nested WithTx, should commit with the outer transaction", func(t *testing.T) {
+		p1, p2 := newCreateMovieParams(), newCreateMovieParams()
+		deleteOnCleanup(t, sut, p1.ID, p2.ID)
+		fnErr := errors.New("fn failed")
+
+		err := sut.WithTx(ctx, func(tx store.Interface) error {
+			if err := tx.Create(ctx, p1); err != nil {
+				return err
+			}
+			if err := tx.WithTx(ctx, func(tx store.Interface) error {
+				return tx.Create(ctx, p2)
+			}); err != nil {
+				return err
+			}
+			return fnErr
+		})
+		require.ErrorIs(t, err, fnErr)
+
+		requireNotExists(t, sut, p1.ID)
+		requireNotExists(t, sut, p2.ID)
+	})
+}
+
+func testList(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	director := uniqueWord()
+	var movies []store.Movie
+	for i, p := range []store.CreateMovieParams{
+		{Title: "Charlie", ReleaseDate: time.Date(2003, time.March, 3, 0, 0, 0, 0, time.UTC), TicketPrice: 30},
+		{Title: "Alpha", ReleaseDate: time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC), TicketPrice: 20},
+		{Title: "Bravo", ReleaseDate: time.Date(2002, time.February, 2, 0, 0, 0, 0, time.UTC), TicketPrice: 10},
+	} {
+		p.ID = uuid.New()
+		p.Director = director
+		movies = append(movies, createMovie(t, sut, p))
+		if i < 2 {
+			// keep created at distinct for stores with second precision
+			time.Sleep(time.Second)
+		}
+	}
+	charlie, alpha, bravo := movies[0], movies[1], movies[2]
+
+	list := func(t *testing.T, p store.ListMoviesParams) store.MoviesPage {
+		t.Helper()
+
+		if p.Director == "" {
+			p.Director = director
+		}
+		page, err := sut.List(ctx, p)
+		require.NoError(t, err)
+		return page
+	}
+
+	t.Run("given no sort, should order by created at", func(t *testing.T) {
+		page := list(t, store.ListMoviesParams{})
+
+		assert.Equal(t, []uuid.UUID{charlie.ID, alpha.ID, bravo.ID}, movieIDs(page.Movies))
+		assert.Nil(t, page.Next)
+	})
+
+	t.Run("given sort field, should order by field", func(t *testing.T) {
+		for _, tc := range []struct {
+			sortBy     store.SortField
+			descending bool
+			expected   []uuid.UUID
+		}{
+			{store.SortByTitle, false, []uuid.UUID{alpha.ID, bravo.ID, charlie.ID}},
+			{store.SortByTitle, true, []uuid.UUID{charlie.ID, bravo.ID, alpha.ID}},
+			{store.SortByReleaseDate, false, []uuid.UUID{alpha.ID, bravo.ID, charlie.ID}},
+			{store.SortByTicketPrice, false, []uuid.UUID{bravo.ID, alpha.ID, charlie.ID}},
+			{store.SortByTicketPrice, true, []uuid.UUID{charlie.ID, alpha.ID, bravo.ID}},
+			{store.SortByCreatedAt, true, []uuid.UUID{bravo.ID, alpha.ID, charlie.ID}},
+		} {
+			page := list(t, store.ListMoviesParams{SortBy: tc.sortBy, Descending: tc.descending})
+
+			assert.Equal(t, tc.expected, movieIDs(page.Movies), "sort by %s, descending %v", tc.sortBy, tc.descending)
+		}
+	})
+
+	t.Run("given limit, should page through records", func(t *testing.T) {
+		for _, descending := range []bool{false, true} {
+			var ids []uuid.UUID
+			p := store.ListMoviesParams{Limit: 2, SortBy: store.SortByTitle, Descending: descending}
+			for pages := 0; ; pages++ {
+				require.Less(t, pages, 3, "expected at most 2 pages")
+
+				page := list(t, p)
+				assert.LessOrEqual(t, len(page.Movies), p.Limit)
+				ids = append(ids, movieIDs(page.Movies)...)
+				if page.Next == nil {
+					break
+				}
+				p.After = page.Next
+			}
+
+			expected := []uuid.UUID{alpha.ID, bravo.ID, charlie.ID}
+			if descending {
+				expected = []uuid.UUID{charlie.ID, bravo.ID, alpha.ID}
+			}
+			assert.Equal(t, expected, ids)
+		}
+	})
+
+	t.Run("given filters, should return matching records", func(t *testing.T) {
+		from := time.Date(2002, time.January, 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2003, time.March, 3, 0, 0, 0, 0, time.UTC)
+		minTicketPrice := 20.0
+		maxTicketPrice := 30.0
+
+		page := list(t, store.ListMoviesParams{Director: strings.ToUpper(director), SortBy: store.SortByTitle})
+		assert.Equal(t, []uuid.UUID{alpha.ID, bravo.ID, charlie.ID}, movieIDs(page.Movies))
+
+		page = list(t, store.ListMoviesParams{SortBy: store.SortByTitle, ReleaseDateFrom: &from, ReleaseDateTo: &to})
+		assert.Equal(t, []uuid.UUID{bravo.ID, charlie.ID}, movieIDs(page.Movies))
+
+		page = list(t, store.ListMoviesParams{SortBy: store.SortByTitle, MinTicketPrice: &minTicketPrice, MaxTicketPrice: &maxTicketPrice})
+		assert.Equal(t, []uuid.UUID{alpha.ID, charlie.ID}, movieIDs(page.Movies))
+	})
+
+	t.Run("given mixed case titles, should order by title byte by byte", func(t *testing.T) {
+		mixedCaseDirector := uniqueWord()
+		titles := map[string]uuid.UUID{}
+		for _, title := range []string{"alpha", "Charlie", "Bravo"} {
+			p := newCreateMovieParams()
+			p.Title = title
+			p.Director = mixedCaseDirector
+			titles[title] = createMovie(t, sut, p).ID
+		}
+
+		for _, descending := range []bool{false, true} {
+			var ids []uuid.UUID
+			p := store.ListMoviesParams{Director: mixedCaseDirector, Limit: 1, SortBy: store.SortByTitle, Descending: descending}
+			for pages := 0; ; pages++ {
+				require.Less(t, pages, 4, "expected at most 3 pages")
+
+				page := list(t, p)
+				ids = append(ids, movieIDs(page.Movies)...)
+				if page.Next == nil {
+					break
+				}
+				p.After = page.Next
+			}
+
+			expected := []uuid.UUID{titles["Bravo"], titles["Charlie"], titles["alpha"]}
+			if descending {
+				expected = []uuid.UUID{titles["alpha"], titles["Charlie"], titles["Bravo"]}
+			}
+			assert.Equal(t, expected, ids, "descending %v", descending)
+		}
+	})
+
+	t.Run("given unsupported sort field, should return ValidationError", func(t *testing.T) {
+		_, err := sut.List(ctx, store.ListMoviesParams{SortBy: "director"})
+
+		var targetErr *store.ValidationError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+}
+
+func testSearch(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	word := uniqueWord()
+	p := newCreateMovieParams()
+	p.Title = word + " Alpha"
+	alpha := createMovie(t, sut, p)
+	p = newCreateMovieParams()
+	p.Title = word + " Bravo"
+	bravo := createMovie(t, sut, p)
+	p = newCreateMovieParams()
+	p.Title = "Charlie"
+	p.Director = word
+	charlie := createMovie(t, sut, p)
+
+	search := func(t *testing.T, p store.SearchMoviesParams) []store.Movie {
+		t.Helper()
+
+		movies, err := sut.Search(ctx, p)
+		require.NoError(t, err)
+		return movies
+	}
+
+	require.Eventually(t, func() bool {
+		movies, err := sut.Search(ctx, store.SearchMoviesParams{Query: word})
+		return err == nil && len(movies) == 3
+	}, searchTimeout, 100*time.Millisecond, "expected search index to contain created movies")
+
+	t.Run("given word, should rank title matches first", func(t *testing.T) {
+		movies := search(t, store.SearchMoviesParams{Query: word})
+
+		require.Len(t, movies, 3)
+		assert.ElementsMatch(t, []uuid.UUID{alpha.ID, bravo.ID}, movieIDs(movies[:2]))
+		assert.Equal(t, charlie.ID, movies[2].ID)
+	})
+
+	t.Run("given prefix, should match words starting with prefix", func(t *testing.T) {
+		movies := search(t, store.SearchMoviesParams{Query: strings.ToUpper(word[:len(word)-4])})
+
+		assert.ElementsMatch(t, []uuid.UUID{alpha.ID, bravo.ID, charlie.ID}, movieIDs(movies))
+	})
+
+	t.Run("given multiple words, should match all words", func(t *testing.T) {
+		movies := search(t, store.SearchMoviesParams{Query: word + " bravo"})
+
+		assert.Equal(t, []uuid.UUID{bravo.ID}, movieIDs(movies))
+	})
+
+	t.Run("given limit, should return at most limit records", func(t *testing.T) {
+		movies := search(t, store.SearchMoviesParams{Query: word, Limit: 2})
+
+		assert.Len(t, movies, 2)
+	})
+
+	t.Run("given query without words, should return no records", func(t *testing.T) {
+		movies := search(t, store.SearchMoviesParams{Query: "!?"})
+
+		assert.Empty(t, movies)
+	})
+
+	t.Run("given more than MaxSearchTerms words, should return ValidationError", func(t *testing.T) {
+		_, err := sut.Search(ctx, store.SearchMoviesParams{Query: strings.Repeat(word+" ", store.MaxSearchTerms+1)})
+
+		var targetErr *store.ValidationError
+		assert.ErrorAs(t, err, &targetErr)
+	})
+}
+
+func testConcurrency(t *testing.T, sut store.Interface) {
+	ctx := context.Background()
+
+	t.Run("given concurrent creates, should create all records", func(t *testing.T) {
+		var ps []store.CreateMovieParams
+		for i := 0; i < concurrency; i++ {
+			ps = append(ps, newCreateMovieParams())
+		}
+
+		errs := make([]error, len(ps))
+		var wg sync.WaitGroup
+		for i, p := range ps {
+			wg.Add(1)
+			go func(i int, p store.CreateMovieParams) {
+				defer wg.Done()
+				errs[i] = sut.Create(ctx, p)
+			}(i, p)
+		}
+		wg.Wait()
+
+		for i, p := range ps {
+			id := p.ID
+			t.Cleanup(func() {
+				sut.Delete(context.Background(), id, store.DeleteMovieParams{})
+			})
+			require.NoError(t, errs[i])
+
+			m, err := sut.GetByID(ctx, p.ID)
+			require.NoError(t, err)
+			assertMovie(t, p, m)
+		}
+	})
+
+	t.Run("given concurrent updates, should keep one of the updates", func(t *testing.T) {
+		created := createMovie(t, sut, newCreateMovieParams())
+
+		titles := map[string]bool{}
+		errs := make([]error, concurrency)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			title := "Concurrent " + uuid.NewString()
+			titles[title] = true
+
+			wg.Add(1)
+			go func(i int, title string) {
+				defer wg.Done()
+				errs[i] = sut.Update(ctx, created.ID, store.UpdateMovieParams{
+					Title:       title,
+					Director:    created.Director,
+					ReleaseDate: created.ReleaseDate,
+					TicketPrice: created.TicketPrice,
+				})
+			}(i, title)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		m, err := sut.GetByID(ctx, created.ID)
+		require.NoError(t, err)
+		assert.True(t, titles[m.Title], "unexpected title %q", m.Title)
+		assert.Equal(t, created.Version+concurrency, m.Version)
+	})
+
+	t.Run("given concurrent updates of the same version, should keep exactly one update", func(t *testing.T) {
+		created := createMovie(t, sut, newCreateMovieParams())
+
+		errs := make([]error, concurrency)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = sut.Update(ctx, created.ID, store.UpdateMovieParams{
+					Title:           created.Title,
+					Director:        created.Director,
+					ReleaseDate:     created.ReleaseDate,
+					TicketPrice:     created.TicketPrice,
+					ExpectedVersion: created.Version,
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			var targetErr *store.VersionMismatchError
+			assert.ErrorAs(t, err, &targetErr)
+		}
+		assert.Equal(t, 1, succeeded)
+
+		m, err := sut.GetByID(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, created.Version+1, m.Version)
+	})
+}
+
+func testPing(t *testing.T, sut store.Interface) {
+	t.Run("should reach the store", func(t *testing.T) {
+		assert.NoError(t, sut.Ping(context.Background()))
+	})
+
+	t.Run("should fail when ctx is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.Error(t, sut.Ping(ctx))
+	})
+}
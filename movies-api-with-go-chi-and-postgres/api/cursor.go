@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/store"
+)
+
+type listCursor struct {
+	Sort string `json:"sort"`
+	store.MovieCursor
+}
+
+func encodeCursor(sort string, movieCursor *store.MovieCursor) (string, error) {
+	data, err := json.Marshal(listCursor{Sort: sort, MovieCursor: *movieCursor})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(sort string, cursor string) (*store.MovieCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.Sort != sort {
+		return nil, errors.New("cursor does not match sort")
+	}
+
+	return &c.MovieCursor, nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/store"
+)
+
+// movieETag returns the strong entity tag of a movie version.
+func movieETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch returns the movie version the request is conditional on, zero
+// if there is no If-Match header or it is "*" as any existing movie matches.
+// Weak and malformed entity tags can never match so they fail the precondition.
+func parseIfMatch(r *http.Request) (int64, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+
+	if len(ifMatch) < 2 || !strings.HasPrefix(ifMatch, `"`) || !strings.HasSuffix(ifMatch, `"`) {
+		return 0, ProblemPreconditionFailed.New(errors.New("If-Match must be a single strong entity tag"))
+	}
+	version, err := strconv.ParseInt(ifMatch[1:len(ifMatch)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, ProblemPreconditionFailed.New(errors.New("If-Match does not match any version of the movie"))
+	}
+
+	return version, nil
+}
+
+// ifMatchAnyError fails the precondition of a request with "If-Match: *"
+// instead of reporting the movie as not found, "*" only matches a movie that
+// exists (RFC 9110 section 13.1.1).
+func ifMatchAnyError(r *http.Request, err error) error {
+	var notFoundErr *store.RecordNotFoundError
+	if errors.As(err, &notFoundErr) && strings.TrimSpace(r.Header.Get("If-Match")) == "*" {
+		return ProblemPreconditionFailed.New(errors.New("If-Match is * but the movie does not exist"))
+	}
+	return err
+}
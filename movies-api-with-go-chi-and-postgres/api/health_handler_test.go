@@ -0,0 +1,92 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/api/apitest"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHealth(t *testing.T) {
+	h := apitest.New(t)
+
+	t.Run("should report ok", func(t *testing.T) {
+		err := h.Client.Health(context.Background())
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("should return health fields", func(t *testing.T) {
+		resp := doRequest(t, h, http.MethodGet, "/health", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, map[string]any{"ok": true}, body)
+	})
+}
+
+func TestGetLive(t *testing.T) {
+	h := apitest.NewWithStore(t, store.MemoryDriver, unreachableStore{store.NewMemoryMoviesStore()})
+
+	resp := doRequest(t, h, http.MethodGet, "/health/live", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, map[string]any{"status": "up"}, body)
+}
+
+// unreachableStore is a store whose database cannot be reached.
+type unreachableStore struct {
+	store.Interface
+}
+
+func (s unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestGetReady(t *testing.T) {
+	type componentHealth struct {
+		Status    string   `json:"status"`
+		LatencyMs *float64 `json:"latency_ms"`
+		Error     string   `json:"error"`
+	}
+	type probeResponse struct {
+		Status     string                     `json:"status"`
+		Components map[string]componentHealth `json:"components"`
+	}
+
+	t.Run("should report ready when the store is reachable", func(t *testing.T) {
+		h := apitest.New(t)
+
+		resp := doRequest(t, h, http.MethodGet, "/health/ready", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body probeResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "up", body.Status)
+		assert.Equal(t, "up", body.Components["store"].Status)
+		assert.NotNil(t, body.Components["store"].LatencyMs)
+		assert.Empty(t, body.Components["store"].Error)
+	})
+
+	t.Run("should report not ready when the store is unreachable", func(t *testing.T) {
+		h := apitest.NewWithStore(t, store.MemoryDriver, unreachableStore{store.NewMemoryMoviesStore()})
+
+		resp := doRequest(t, h, http.MethodGet, "/health/ready", "")
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+		var body probeResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "down", body.Status)
+		assert.Equal(t, "down", body.Components["store"].Status)
+		assert.Equal(t, "connection refused", body.Components["store"].Error)
+	})
+}
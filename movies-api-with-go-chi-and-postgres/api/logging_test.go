@@ -0,0 +1,100 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/api"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenStore is a store whose reads fail with an error the API does not know.
+type brokenStore struct {
+	store.Interface
+}
+
+func (s brokenStore) GetByID(ctx context.Context, id uuid.UUID) (store.Movie, error) {
+	return store.Movie{}, errors.New("connection reset by peer")
+}
+
+// serveLogged serves req with a server configured by cfg logging in JSON and
+// returns the response and the decoded log entries.
+func serveLogged(t *testing.T, cfg config.HTTPServer, s store.Interface, req *http.Request) (*httptest.ResponseRecorder, []map[string]any) {
+	t.Helper()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	server := api.NewServer(cfg, s, prometheus.NewRegistry(), logger, nil, nil)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	var entries []map[string]any
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var entry map[string]any
+		require.NoError(t, decoder.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	return w, entries
+}
+
+func TestRequestLogging(t *testing.T) {
+	t.Run("should log requests with the incoming request id", func(t *testing.T) {
+		id := uuid.NewString()
+		req := httptest.NewRequest(http.MethodGet, "/api/movies/"+id, nil)
+		req.Header.Set("X-Request-ID", "request-1")
+
+		w, entries := serveLogged(t, config.HTTPServer{}, store.NewMemoryMoviesStore(), req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "request-1", w.Header().Get("X-Request-ID"))
+		require.Len(t, entries, 1)
+		entry := entries[0]
+		assert.Equal(t, "INFO", entry["level"])
+		assert.Equal(t, "request", entry["msg"])
+		assert.Equal(t, "request-1", entry["request_id"])
+		assert.Equal(t, http.MethodGet, entry["method"])
+		assert.Equal(t, "/api/movies/{id}", entry["route"])
+		assert.Equal(t, "/api/movies/"+id, entry["path"])
+		assert.Equal(t, float64(http.StatusNotFound), entry["status"])
+		assert.Equal(t, float64(w.Body.Len()), entry["bytes"])
+		assert.Contains(t, entry, "duration_ms")
+	})
+
+	t.Run("should assign a request id", func(t *testing.T) {
+		w, entries := serveLogged(t, config.HTTPServer{}, store.NewMemoryMoviesStore(), httptest.NewRequest(http.MethodGet, "/health/live", nil))
+
+		id := w.Header().Get("X-Request-ID")
+		assert.NotEmpty(t, id)
+		require.Len(t, entries, 1)
+		assert.Equal(t, id, entries[0]["request_id"])
+	})
+
+	t.Run("should log the error behind an internal server error", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/movies/"+uuid.NewString(), nil)
+		req.Header.Set("X-Request-ID", "request-2")
+
+		w, entries := serveLogged(t, config.HTTPServer{}, brokenStore{store.NewMemoryMoviesStore()}, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotContains(t, w.Body.String(), "connection reset by peer")
+		require.Len(t, entries, 2)
+		assert.Equal(t, "ERROR", entries[0]["level"])
+		assert.Equal(t, "internal server error", entries[0]["msg"])
+		assert.Equal(t, "request-2", entries[0]["request_id"])
+		assert.Equal(t, "connection reset by peer", entries[0]["error"])
+		assert.Equal(t, float64(http.StatusInternalServerError), entries[1]["status"])
+	})
+}
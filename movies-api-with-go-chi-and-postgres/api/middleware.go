@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// panicError is the error behind the problem rendered for a panic, it is logged
+// with the stack of the goroutine that panicked.
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+func (e *panicError) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("panic", fmt.Sprint(e.value)),
+		slog.String("stack", string(e.stack)),
+	)
+}
+
+// recoverPanics turns a panic in a handler into an internal server error, the
+// connection is kept and the panic is logged like any other internal error.
+// A handler that already wrote its header has sent its status, the panic is
+// only logged then.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			problem := ProblemInternalServerError.New(&panicError{value: p, stack: debug.Stack()})
+			if ww.Status() != 0 {
+				logProblem(r, problem)
+				return
+			}
+			renderError(ww, r, problem)
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// timeout sets a deadline on the context of the request, which the store calls
+// of the handlers are made with. The deadline is the RouteTimeouts entry of the
+// method and pattern of the route, d if it has none, zero means no timeout. It
+// must run once the route is matched, i.e. with chi's With.
+func (s *Server) timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := d
+			if routeTimeout, ok := s.cfg.RouteTimeouts[r.Method+" "+routePattern(r)]; ok {
+				d = routeTimeout
+			}
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/api"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// panickingStore is a store whose reads panic.
+type panickingStore struct {
+	store.Interface
+}
+
+func (s panickingStore) GetByID(ctx context.Context, id uuid.UUID) (store.Movie, error) {
+	panic("index out of range")
+}
+
+// slowStore is a store whose reads only return once ctx is done.
+type slowStore struct {
+	store.Interface
+}
+
+func (s slowStore) GetByID(ctx context.Context, id uuid.UUID) (store.Movie, error) {
+	<-ctx.Done()
+	return store.Movie{}, ctx.Err()
+}
+
+func TestRecoverPanics(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/movies/"+uuid.NewString(), nil)
+
+	w, entries := serveLogged(t, config.HTTPServer{}, panickingStore{store.NewMemoryMoviesStore()}, req)
+
+	t.Run("should respond with an internal server error problem", func(t *testing.T) {
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		var problem api.Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, api.ProblemInternalServerError.Type, problem.Type)
+		assert.Empty(t, problem.Detail)
+	})
+
+	t.Run("should log the panic with its stack", func(t *testing.T) {
+		require.Len(t, entries, 2)
+		assert.Equal(t, "ERROR", entries[0]["level"])
+		panicErr, ok := entries[0]["error"].(map[string]any)
+		require.True(t, ok)
+		assert.Equal(t, "index out of range", panicErr["panic"])
+		assert.Contains(t, panicErr["stack"], "panickingStore.GetByID")
+		assert.Equal(t, float64(http.StatusInternalServerError), entries[1]["status"])
+	})
+}
+
+func TestRequestTimeout(t *testing.T) {
+	cfg := config.HTTPServer{RequestTimeout: 10 * time.Millisecond}
+	req := httptest.NewRequest(http.MethodGet, "/api/movies/"+uuid.NewString(), nil)
+
+	w, _ := serveLogged(t, cfg, slowStore{store.NewMemoryMoviesStore()}, req)
+
+	require.Equal(t, http.StatusGatewayTimeout, w.Code)
+	var problem api.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, api.ProblemTimeout.Type, problem.Type)
+}
+
+func TestRouteTimeout(t *testing.T) {
+	cfg := config.HTTPServer{RouteTimeouts: config.RouteTimeouts{"GET /api/movies/{id}": 10 * time.Millisecond}}
+	req := httptest.NewRequest(http.MethodGet, "/api/movies/"+uuid.NewString(), nil)
+
+	w, _ := serveLogged(t, cfg, slowStore{store.NewMemoryMoviesStore()}, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func TestClientClosedRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/movies/"+uuid.NewString(), nil).WithContext(ctx)
+
+	w, entries := serveLogged(t, config.HTTPServer{}, slowStore{store.NewMemoryMoviesStore()}, req)
+
+	t.Run("should respond with a client closed request problem", func(t *testing.T) {
+		assert.Equal(t, 499, w.Code)
+		assert.Contains(t, w.Body.String(), api.ProblemClientClosedRequest.Type)
+	})
+
+	t.Run("should not log an internal error", func(t *testing.T) {
+		require.Len(t, entries, 1)
+		assert.Equal(t, "INFO", entries[0]["level"])
+		assert.Equal(t, float64(499), entries[0]["status"])
+	})
+}
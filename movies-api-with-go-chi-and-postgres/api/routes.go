@@ -3,20 +3,35 @@ package api
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/auth"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (s *Server) routes() {
+	s.router.Use(requestID)
+	s.router.Use(traceRequests)
+	s.router.Use(s.logRequests)
+	s.router.Use(s.httpMetrics.instrument)
+	s.router.Use(recoverPanics)
 	s.router.Use(render.SetContentType(render.ContentTypeJSON))
 
 	s.router.Get("/health", s.handleGetHealth)
+	s.router.Get("/health/live", s.handleGetLive)
+	s.router.Get("/health/ready", s.handleGetReady)
+	s.router.Get("/metrics", promhttp.HandlerFor(s.metrics, promhttp.HandlerOpts{}).ServeHTTP)
 
+	s.router.With(s.limitIP, s.authenticate, s.authorize(auth.ScopeWrite), s.timeout(s.cfg.BatchTimeout)).Post("/api/movies:batch", s.handleBatchMovies)
 	s.router.Route("/api/movies", func(r chi.Router) {
-		r.Get("/", s.handleListMovies)
-		r.Post("/", s.handleCreateMovie)
+		r.Use(s.limitIP)
+		r.Use(s.authenticate)
+		r.With(s.limitRate, s.authorize(auth.ScopeRead), s.timeout(s.cfg.RequestTimeout)).Get("/", s.handleListMovies)
+		r.With(s.limitRate, s.authorize(auth.ScopeWrite), s.timeout(s.cfg.RequestTimeout)).Post("/", s.handleCreateMovie)
+		r.With(s.limitRate, s.authorize(auth.ScopeRead), s.timeout(s.cfg.RequestTimeout)).Get("/search", s.handleSearchMovies)
 		r.Route("/{id}", func(r chi.Router) {
-			r.Get("/", s.handleGetMovie)
-			r.Put("/", s.handleUpdateMovie)
-			r.Delete("/", s.handleDeleteMovie)
+			r.With(s.limitRate, s.authorize(auth.ScopeRead), s.timeout(s.cfg.RequestTimeout)).Get("/", s.handleGetMovie)
+			r.With(s.limitRate, s.authorize(auth.ScopeWrite), s.timeout(s.cfg.RequestTimeout)).Put("/", s.handleUpdateMovie)
+			r.With(s.limitRate, s.authorize(auth.ScopeWrite), s.timeout(s.cfg.RequestTimeout)).Patch("/", s.handlePatchMovie)
+			r.With(s.limitRate, s.authorize(auth.ScopeDelete), s.timeout(s.cfg.RequestTimeout)).Delete("/", s.handleDeleteMovie)
 		})
 	})
 }
@@ -3,29 +3,52 @@ package api
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/auth"
 	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/ratelimit"
 	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/store"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Server struct {
-	cfg    config.HTTPServer
-	store  store.Interface
-	router *chi.Mux
+	cfg         config.HTTPServer
+	store       store.Interface
+	router      *chi.Mux
+	metrics     *prometheus.Registry
+	httpMetrics *httpMetrics
+	logger      *slog.Logger
+	// authenticator is nil if authentication is disabled
+	authenticator *auth.Authenticator
+	// limiter is nil if rate limiting is disabled
+	limiter *ratelimit.Limiter
+	// shuttingDown is set once Start received a shutdown signal
+	shuttingDown atomic.Bool
 }
 
-func NewServer(cfg config.HTTPServer, store store.Interface) *Server {
+// NewServer returns a server for store, it registers its HTTP metrics in
+// metrics and serves everything registered there on /metrics. Requests and
+// errors are logged to logger. The movie routes are open to anyone if
+// authenticator is nil and not rate limited if limiter is nil.
+func NewServer(cfg config.HTTPServer, store store.Interface, metrics *prometheus.Registry, logger *slog.Logger, authenticator *auth.Authenticator, limiter *ratelimit.Limiter) *Server {
 	srv := &Server{
-		cfg:    cfg,
-		store:  store,
-		router: chi.NewRouter(),
+		cfg:           cfg,
+		store:         store,
+		router:        chi.NewRouter(),
+		metrics:       metrics,
+		httpMetrics:   newHTTPMetrics(metrics),
+		logger:        logger,
+		authenticator: authenticator,
+		limiter:       limiter,
 	}
 
 	srv.routes()
@@ -33,6 +56,10 @@ func NewServer(cfg config.HTTPServer, store store.Interface) *Server {
 	return srv
 }
 
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
 func (s *Server) Start(ctx context.Context) {
 	server := http.Server{
 		Addr:         fmt.Sprintf(":%d", s.cfg.Port),
@@ -40,21 +67,24 @@ func (s *Server) Start(ctx context.Context) {
 		IdleTimeout:  s.cfg.IdleTimeout,
 		ReadTimeout:  s.cfg.ReadTimeout,
 		WriteTimeout: s.cfg.WriteTimeout,
+		ErrorLog:     slog.NewLogLogger(s.logger.Handler(), slog.LevelError),
 	}
 
 	shutdownComplete := handleShutdown(func() {
+		s.shuttingDown.Store(true)
+		time.Sleep(s.cfg.ShutdownDelay)
 		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("server.Shutdown failed: %v\n", err)
+			s.logger.Error("server.Shutdown failed", slog.Any("error", err))
 		}
 	})
 
 	if err := server.ListenAndServe(); err == http.ErrServerClosed {
 		<-shutdownComplete
 	} else {
-		log.Printf("http.ListenAndServe failed: %v\n", err)
+		s.logger.Error("http.ListenAndServe failed", slog.Any("error", err))
 	}
 
-	log.Println("Shutdown gracefully")
+	s.logger.Info("Shutdown gracefully")
 }
 
 func handleShutdown(onShutdownSignal func()) <-chan struct{} {
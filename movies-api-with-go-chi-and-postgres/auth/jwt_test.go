@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonWebKeyJSON(t *testing.T, kid string) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return fmt.Sprintf(`{"kty":"RSA","kid":%q,"n":%q,"e":%q}`,
+		kid,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	)
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	key1, key2 := jsonWebKeyJSON(t, "key-1"), jsonWebKeyJSON(t, "key-2")
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			fmt.Fprintf(w, `{"keys":[%s]}`, key1)
+			return
+		}
+		<-release
+		fmt.Fprintf(w, `{"keys":[%s,%s]}`, key1, key2)
+	}))
+	t.Cleanup(server.Close)
+
+	sut := &jwks{url: server.URL, ttl: time.Minute, client: server.Client()}
+	require.NoError(t, sut.refresh(context.Background()))
+	sut.attemptedAt = time.Time{}
+
+	t.Run("should not abort the refresh when the client that started it goes away", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := sut.key(ctx, "key-2")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("should verify with the keys read last while refreshing", func(t *testing.T) {
+		key, err := sut.key(context.Background(), "key-1")
+		require.NoError(t, err)
+		assert.NotNil(t, key)
+	})
+
+	t.Run("should wait for the refresh in flight for an unknown key", func(t *testing.T) {
+		close(release)
+
+		key, err := sut.key(context.Background(), "key-2")
+		require.NoError(t, err)
+		assert.NotNil(t, key)
+		assert.Equal(t, int32(2), fetches.Load())
+	})
+}
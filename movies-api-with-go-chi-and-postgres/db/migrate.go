@@ -0,0 +1,95 @@
+// Package db embeds the schema migrations so the binary can apply them itself
+// instead of relying on the migrate/migrate container.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/jackc/pgx/stdlib"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/config"
+)
+
+const (
+	driverName = "pgx"
+	// how long Up waits for another replica holding the migration lock
+	lockWaitTimeout   = time.Minute
+	lockRetryInterval = time.Second
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// NewMigrate returns a migrate instance for the embedded migrations, the
+// caller is responsible for closing it.
+func NewMigrate(config config.Database) (*migrate.Migrate, error) {
+	source, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driverName, config.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Up applies all pending migrations. Migrations run under an advisory lock,
+// if another replica holds it Up waits for it to finish instead of failing.
+func Up(ctx context.Context, config config.Database) error {
+	m, err := NewMigrate(config)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, lockWaitTimeout)
+	defer cancel()
+
+	for {
+		err := m.Up()
+		if errors.Is(err, migrate.ErrNoChange) {
+			return nil
+		}
+		if !isLockError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// isLockError reports whether err is caused by another session holding the
+// migration lock, drivers either return ErrLocked or a failed try lock.
+func isLockError(err error) bool {
+	var dbErr *database.Error
+	if errors.As(err, &dbErr) {
+		return dbErr.OrigErr == nil && strings.HasPrefix(dbErr.Err, "try lock failed")
+	}
+	return errors.Is(err, database.ErrLocked) || errors.Is(err, migrate.ErrLockTimeout)
+}
@@ -0,0 +1,6 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', director), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_search_idx ON movies USING GIN (search);
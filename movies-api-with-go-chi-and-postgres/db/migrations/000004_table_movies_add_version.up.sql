@@ -0,0 +1 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS version BIGINT DEFAULT 1 NOT NULL;
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/db"
+)
+
+var errMigrateUsage = errors.New("usage: migrate up [N] | down [N] | version | force VERSION")
+
+// runMigrate runs the migrate subcommand, up applies all pending migrations
+// unless N is given and down rolls back a single migration unless N is given.
+func runMigrate(ctx context.Context, config config.Database, args []string) error {
+	var run func(m *migrate.Migrate) error
+	switch {
+	case len(args) == 1 && args[0] == "up":
+		return db.Up(ctx, config)
+	case len(args) == 2 && args[0] == "up":
+		n, err := parseSteps(args[1])
+		if err != nil {
+			return err
+		}
+		run = func(m *migrate.Migrate) error { return m.Steps(n) }
+	case len(args) == 1 && args[0] == "down":
+		run = func(m *migrate.Migrate) error { return m.Steps(-1) }
+	case len(args) == 2 && args[0] == "down":
+		n, err := parseSteps(args[1])
+		if err != nil {
+			return err
+		}
+		run = func(m *migrate.Migrate) error { return m.Steps(-n) }
+	case len(args) == 1 && args[0] == "version":
+		run = printVersion
+	case len(args) == 2 && args[0] == "force":
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errMigrateUsage
+		}
+		run = func(m *migrate.Migrate) error { return m.Force(version) }
+	default:
+		return errMigrateUsage
+	}
+
+	m, err := db.NewMigrate(config)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := run(m); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migrate %s: %w", args[0], err)
+	}
+	return nil
+}
+
+func parseSteps(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return 0, errMigrateUsage
+	}
+	return n, nil
+}
+
+func printVersion(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		slog.Info("no migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("migration version", slog.Uint64("version", uint64(version)), slog.Bool("dirty", dirty))
+	return nil
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/config"
+)
+
+// MemoryBackend is the RATE_LIMIT_BACKEND name of MemoryLimiter.
+const MemoryBackend = "memory"
+
+// sweepInterval is how often full buckets are dropped, a full bucket is the
+// same as no bucket.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	limit     Limit
+}
+
+// refill adds the tokens accrued since the bucket was last updated.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updatedAt).Seconds()*b.limit.Rate)
+	b.updatedAt = now
+}
+
+// MemoryLimiter keeps the buckets in memory, so each instance of the service
+// limits its clients on its own.
+type MemoryLimiter struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (m *MemoryLimiter) Take(ctx context.Context, key string, limit Limit, cost int) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.sweptAt) > sweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), updatedAt: now, limit: limit}
+		m.buckets[key] = b
+	}
+	b.refill(now)
+
+	result := Result{Limit: limit.Burst}
+	need := math.Min(float64(cost), float64(limit.Burst))
+	if b.tokens >= need {
+		b.tokens -= float64(cost)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((need - b.tokens) / limit.Rate)
+	}
+	result.Remaining = int(math.Max(b.tokens, 0))
+	result.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return result, nil
+}
+
+func (m *MemoryLimiter) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+	m.sweptAt = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func init() {
+	Register(MemoryBackend, func(config config.RateLimit) (Backend, error) {
+		return NewMemoryLimiter(), nil
+	})
+}
@@ -0,0 +1,146 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newConfig() config.RateLimit {
+	return config.RateLimit{Backend: ratelimit.MemoryBackend, Rate: 0.001, Burst: 2}
+}
+
+func TestNew(t *testing.T) {
+	tests := map[string]func(config *config.RateLimit){
+		"unknown backends":   func(config *config.RateLimit) { config.Backend = "redis" },
+		"zero rates":         func(config *config.RateLimit) { config.Rate = 0 },
+		"zero bursts":        func(config *config.RateLimit) { config.Burst = 0 },
+		"routes without =":   func(config *config.RateLimit) { config.Routes = []string{"GET /api/movies"} },
+		"routes without /":   func(config *config.RateLimit) { config.Routes = []string{"GET api/movies=1:5"} },
+		"routes without :":   func(config *config.RateLimit) { config.Routes = []string{"GET /api/movies=1"} },
+		"invalid rates":      func(config *config.RateLimit) { config.Routes = []string{"GET /api/movies=fast:5"} },
+		"negative bursts":    func(config *config.RateLimit) { config.Routes = []string{"GET /api/movies=1:-5"} },
+		"zero IP bursts":     func(config *config.RateLimit) { config.IPRate = 1 },
+		"invalid proxy CIDR": func(config *config.RateLimit) { config.TrustedProxies = []string{"10.0.0.1"} },
+	}
+
+	for name, modify := range tests {
+		t.Run("should reject "+name, func(t *testing.T) {
+			config := newConfig()
+			modify(&config)
+
+			_, err := ratelimit.New(config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestTake(t *testing.T) {
+	config := newConfig()
+	config.Routes = []string{"POST /api/movies:batch=0.001:1"}
+	sut, err := ratelimit.New(config)
+	require.NoError(t, err)
+
+	take := func(route string, client string) ratelimit.Result {
+		result, err := sut.Take(context.Background(), route, client, 1)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("should allow the burst and then deny", func(t *testing.T) {
+		result := take("GET /api/movies", "client-1")
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 2, result.Limit)
+		assert.Equal(t, 1, result.Remaining)
+
+		assert.True(t, take("GET /api/movies", "client-1").Allowed)
+
+		result = take("GET /api/movies", "client-1")
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 0, result.Remaining)
+		assert.Positive(t, result.RetryAfter)
+		assert.GreaterOrEqual(t, result.Reset, result.RetryAfter)
+	})
+
+	t.Run("should keep a bucket per client and route", func(t *testing.T) {
+		assert.True(t, take("GET /api/movies", "client-2").Allowed)
+		assert.True(t, take("GET /api/movies/{id}", "client-1").Allowed)
+	})
+
+	t.Run("should apply the limit of the route", func(t *testing.T) {
+		assert.True(t, take("POST /api/movies:batch", "client-1").Allowed)
+		assert.False(t, take("POST /api/movies:batch", "client-1").Allowed)
+	})
+}
+
+func TestTakeIP(t *testing.T) {
+	t.Run("should allow every request with the IP limit off", func(t *testing.T) {
+		sut, err := ratelimit.New(newConfig())
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			result, err := sut.TakeIP(context.Background(), "203.0.113.7")
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+		}
+	})
+
+	t.Run("should allow the IP burst and then deny", func(t *testing.T) {
+		config := newConfig()
+		config.IPRate, config.IPBurst = 0.001, 1
+		sut, err := ratelimit.New(config)
+		require.NoError(t, err)
+
+		result, err := sut.TakeIP(context.Background(), "203.0.113.7")
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+
+		result, err = sut.TakeIP(context.Background(), "203.0.113.7")
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+
+		result, err = sut.Take(context.Background(), "GET /api/movies", "ip:203.0.113.7", 1)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	config := newConfig()
+	config.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1/32"}
+	sut, err := ratelimit.New(config)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expectedIP   string
+	}{
+		{name: "should use the remote address of direct clients", remoteAddr: "203.0.113.7:52000", expectedIP: "203.0.113.7"},
+		{name: "should ignore X-Forwarded-For from untrusted clients", remoteAddr: "203.0.113.7:52000", forwardedFor: []string{"198.51.100.1"}, expectedIP: "203.0.113.7"},
+		{name: "should use X-Forwarded-For from trusted proxies", remoteAddr: "10.1.2.3:52000", forwardedFor: []string{"198.51.100.1"}, expectedIP: "198.51.100.1"},
+		{name: "should skip trusted proxies in X-Forwarded-For", remoteAddr: "10.1.2.3:52000", forwardedFor: []string{"198.51.100.1, 192.168.1.1", "10.4.5.6"}, expectedIP: "198.51.100.1"},
+		{name: "should not trust addresses forged before the client", remoteAddr: "10.1.2.3:52000", forwardedFor: []string{"10.9.9.9, 198.51.100.1"}, expectedIP: "198.51.100.1"},
+		{name: "should stop at malformed addresses", remoteAddr: "10.1.2.3:52000", forwardedFor: []string{"unknown, 10.4.5.6"}, expectedIP: "10.4.5.6"},
+		{name: "should unmap IPv4 addresses", remoteAddr: "[::ffff:203.0.113.7]:52000", expectedIP: "203.0.113.7"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			assert.Equal(t, tc.expectedIP, sut.ClientIP(r))
+		})
+	}
+}
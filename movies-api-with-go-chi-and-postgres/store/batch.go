@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+type BatchOperationType string
+
+const (
+	BatchCreate BatchOperationType = "create"
+	BatchUpdate BatchOperationType = "update"
+	BatchDelete BatchOperationType = "delete"
+)
+
+// BatchOperation is a single write in a batch, only the params matching Type
+// are used. ID identifies the movie to update or delete, creates take the id
+// from CreateMovieParams.
+type BatchOperation struct {
+	Type   BatchOperationType
+	ID     uuid.UUID
+	Create CreateMovieParams
+	Update UpdateMovieParams
+	Delete DeleteMovieParams
+}
+
+// batch implements Interface.Batch, an atomic batch runs in a transaction
+// that is rolled back if any operation fails.
+func batch(ctx context.Context, s Interface, operations []BatchOperation, atomic bool) ([]error, error) {
+	if !atomic {
+		return runBatch(ctx, s, operations, false), nil
+	}
+
+	var results []error
+	err := s.WithTx(ctx, func(tx Interface) error {
+		results = runBatch(ctx, tx, operations, true)
+		return batchFailed(results)
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		return nil, err
+	}
+	return results, nil
+}
+
+// errBatchFailed rolls back the transaction of an atomic batch, the errors of
+// the individual operations are reported in the batch results instead.
+var errBatchFailed = errors.New("batch failed")
+
+// runBatch runs operations against s in order and returns the error of each
+// operation. An atomic batch stops at the first failure, every other operation
+// is reported as aborted and the caller is expected to roll back; consecutive
+// creates are inserted together with CreateMany.
+func runBatch(ctx context.Context, s Interface, operations []BatchOperation, atomic bool) []error {
+	results := make([]error, len(operations))
+	for i := 0; i < len(operations); i++ {
+		if !atomic {
+			results[i] = runBatchOperation(ctx, s, operations[i])
+			continue
+		}
+
+		end := i + 1
+		if operations[i].Type == BatchCreate {
+			for end < len(operations) && operations[end].Type == BatchCreate {
+				end++
+			}
+		}
+
+		failed, err := i, error(nil)
+		if end-i > 1 {
+			createMoviesParams := make([]CreateMovieParams, 0, end-i)
+			for _, operation := range operations[i:end] {
+				createMoviesParams = append(createMoviesParams, operation.Create)
+			}
+			err = s.CreateMany(ctx, createMoviesParams)
+			var duplicateKeyErr *DuplicateKeyError
+			if errors.As(err, &duplicateKeyErr) {
+				for j, operation := range operations[i:end] {
+					if operation.Create.ID == duplicateKeyErr.ID {
+						failed = i + j
+						break
+					}
+				}
+			}
+		} else {
+			err = runBatchOperation(ctx, s, operations[i])
+		}
+
+		if err != nil {
+			for j := range results {
+				results[j] = &BatchAbortedError{FailedIndex: failed}
+			}
+			results[failed] = err
+			return results
+		}
+		i = end - 1
+	}
+
+	return results
+}
+
+func runBatchOperation(ctx context.Context, s Interface, operation BatchOperation) error {
+	switch operation.Type {
+	case BatchCreate:
+		return s.Create(ctx, operation.Create)
+	case BatchUpdate:
+		return s.Update(ctx, operation.ID, operation.Update)
+	case BatchDelete:
+		return s.Delete(ctx, operation.ID, operation.Delete)
+	default:
+		return &ValidationError{Field: "type", Message: fmt.Sprintf("unsupported batch operation %q", operation.Type)}
+	}
+}
+
+// batchFailed returns errBatchFailed if any operation in results failed.
+func batchFailed(results []error) error {
+	for _, err := range results {
+		if err != nil {
+			return errBatchFailed
+		}
+	}
+	return nil
+}
+
+// duplicateMovieID returns the first id repeated in createMoviesParams, a
+// multi-row insert would otherwise fail without telling which movie clashed.
+func duplicateMovieID(createMoviesParams []CreateMovieParams) (uuid.UUID, bool) {
+	seen := make(map[uuid.UUID]struct{}, len(createMoviesParams))
+	for _, p := range createMoviesParams {
+		if _, ok := seen[p.ID]; ok {
+			return p.ID, true
+		}
+		seen[p.ID] = struct{}{}
+	}
+	return uuid.Nil, false
+}
@@ -19,3 +19,31 @@ type RecordNotFoundError struct{}
 func (e *RecordNotFoundError) Error() string {
 	return "record not found"
 }
+
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Message)
+}
+
+type VersionMismatchError struct {
+	ID              uuid.UUID
+	ExpectedVersion int64
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("movie id %v is not at version %d", e.ID, e.ExpectedVersion)
+}
+
+// BatchAbortedError is reported for the other operations of an atomic batch
+// when one of them fails and the batch is rolled back.
+type BatchAbortedError struct {
+	FailedIndex int
+}
+
+func (e *BatchAbortedError) Error() string {
+	return fmt.Sprintf("batch aborted, operation %d failed", e.FailedIndex)
+}
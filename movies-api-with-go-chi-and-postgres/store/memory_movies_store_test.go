@@ -0,0 +1,106 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/store"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/store/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMoviesStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Interface {
+		return store.NewMemoryMoviesStore()
+	})
+}
+
+func TestMemoryMoviesStoreWithTx(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("given deletes and creates in tx, should read them within tx", func(t *testing.T) {
+		sut := store.NewMemoryMoviesStore()
+		deleted := store.CreateMovieParams{ID: uuid.New(), Title: "Deleted Transaction", Director: "Memory Store", TicketPrice: 10}
+		require.NoError(t, sut.Create(ctx, deleted))
+		created := store.CreateMovieParams{ID: uuid.New(), Title: "Created Transaction", Director: "Memory Store", TicketPrice: 10}
+
+		err := sut.WithTx(ctx, func(tx store.Interface) error {
+			require.NoError(t, tx.Delete(ctx, deleted.ID, store.DeleteMovieParams{}))
+			require.NoError(t, tx.Create(ctx, created))
+
+			page, err := tx.List(ctx, store.ListMoviesParams{})
+			require.NoError(t, err)
+			assert.Equal(t, []uuid.UUID{created.ID}, movieIDs(page.Movies))
+			movies, err := tx.Search(ctx, store.SearchMoviesParams{Query: "transaction"})
+			require.NoError(t, err)
+			assert.Equal(t, []uuid.UUID{created.ID}, movieIDs(movies))
+			return nil
+		})
+		require.NoError(t, err)
+
+		movies, err := sut.Search(ctx, store.SearchMoviesParams{Query: "transaction"})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{created.ID}, movieIDs(movies))
+	})
+
+	t.Run("given movie deleted and created again in tx, should commit the new movie", func(t *testing.T) {
+		sut := store.NewMemoryMoviesStore()
+		p := store.CreateMovieParams{ID: uuid.New(), Title: "Original", Director: "Memory Store", TicketPrice: 10}
+		require.NoError(t, sut.Create(ctx, p))
+
+		err := sut.WithTx(ctx, func(tx store.Interface) error {
+			require.NoError(t, tx.Delete(ctx, p.ID, store.DeleteMovieParams{}))
+			p.Title = "Recreated"
+			return tx.Create(ctx, p)
+		})
+		require.NoError(t, err)
+
+		m, err := sut.GetByID(ctx, p.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Recreated", m.Title)
+		movies, err := sut.Search(ctx, store.SearchMoviesParams{Query: "original"})
+		require.NoError(t, err)
+		assert.Empty(t, movies)
+	})
+}
+
+func TestMemoryMoviesStoreSearch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("given tokens around the prefix, should match only tokens starting with it", func(t *testing.T) {
+		sut := store.NewMemoryMoviesStore()
+		ids := map[string]uuid.UUID{}
+		for _, title := range []string{"Tram", "Trainspotting", "Train", "Trap"} {
+			ids[title] = uuid.New()
+			require.NoError(t, sut.Create(ctx, store.CreateMovieParams{ID: ids[title], Title: title, Director: "Memory Store", TicketPrice: 10}))
+		}
+
+		movies, err := sut.Search(ctx, store.SearchMoviesParams{Query: "train"})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{ids["Train"], ids["Trainspotting"]}, movieIDs(movies))
+
+		require.NoError(t, sut.Delete(ctx, ids["Train"], store.DeleteMovieParams{}))
+		err = sut.WithTx(ctx, func(tx store.Interface) error {
+			require.NoError(t, tx.Create(ctx, store.CreateMovieParams{ID: uuid.New(), Title: "Trail", Director: "Memory Store", TicketPrice: 10}))
+			movies, err := tx.Search(ctx, store.SearchMoviesParams{Query: "trai"})
+			require.NoError(t, err)
+			assert.Len(t, movies, 2)
+			return nil
+		})
+		require.NoError(t, err)
+
+		movies, err = sut.Search(ctx, store.SearchMoviesParams{Query: "train"})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{ids["Trainspotting"]}, movieIDs(movies))
+	})
+}
+
+func movieIDs(movies []store.Movie) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, m := range movies {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
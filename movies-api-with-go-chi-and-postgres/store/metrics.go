@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+type storeMetrics struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// InstrumentedStore records the latency and errors of every call to the store
+// it wraps, calls through the tx of WithTx are recorded as well.
+type InstrumentedStore struct {
+	next    Interface
+	metrics *storeMetrics
+}
+
+// NewInstrumentedStore wraps s and registers its metrics in reg. The pool
+// stats of a store backed by a *sql.DB are registered too, labelled with name.
+func NewInstrumentedStore(s Interface, name string, reg prometheus.Registerer) *InstrumentedStore {
+	m := &storeMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "store_call_duration_seconds",
+			Help:    "Latency of store calls by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "store_call_errors_total",
+			Help: "Number of failed store calls by method and error.",
+		}, []string{"method", "error"}),
+	}
+	reg.MustRegister(m.duration, m.errors)
+
+	if db, ok := s.(interface{ DB() *sql.DB }); ok {
+		reg.MustRegister(collectors.NewDBStatsCollector(db.DB(), name))
+	}
+
+	return &InstrumentedStore{next: s, metrics: m}
+}
+
+// observe records a call to method that started at start and returned err.
+func (s *InstrumentedStore) observe(method string, start time.Time, err error) {
+	s.metrics.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.metrics.errors.WithLabelValues(method, errorLabel(err)).Inc()
+	}
+}
+
+// errorLabel names the kind of a store error, errors the API maps to a client
+// error are told apart from failures of the store itself.
+func errorLabel(err error) string {
+	var (
+		duplicateKeyErr    *DuplicateKeyError
+		recordNotFoundErr  *RecordNotFoundError
+		validationErr      *ValidationError
+		versionMismatchErr *VersionMismatchError
+	)
+	switch {
+	case errors.As(err, &duplicateKeyErr):
+		return "duplicate_key"
+	case errors.As(err, &recordNotFoundErr):
+		return "not_found"
+	case errors.As(err, &validationErr):
+		return "validation"
+	case errors.As(err, &versionMismatchErr):
+		return "version_mismatch"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "other"
+	}
+}
+
+func (s *InstrumentedStore) GetAll(ctx context.Context) ([]Movie, error) {
+	start := time.Now()
+	movies, err := s.next.GetAll(ctx)
+	s.observe("GetAll", start, err)
+	return movies, err
+}
+
+func (s *InstrumentedStore) List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error) {
+	start := time.Now()
+	page, err := s.next.List(ctx, listMoviesParams)
+	s.observe("List", start, err)
+	return page, err
+}
+
+func (s *InstrumentedStore) Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error) {
+	start := time.Now()
+	movies, err := s.next.Search(ctx, searchMoviesParams)
+	s.observe("Search", start, err)
+	return movies, err
+}
+
+func (s *InstrumentedStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
+	start := time.Now()
+	movie, err := s.next.GetByID(ctx, id)
+	s.observe("GetByID", start, err)
+	return movie, err
+}
+
+func (s *InstrumentedStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
+	start := time.Now()
+	err := s.next.Create(ctx, createMovieParams)
+	s.observe("Create", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error {
+	start := time.Now()
+	err := s.next.CreateMany(ctx, createMoviesParams)
+	s.observe("CreateMany", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
+	start := time.Now()
+	err := s.next.Update(ctx, id, updateMovieParams)
+	s.observe("Update", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error {
+	start := time.Now()
+	err := s.next.Patch(ctx, id, patchMovieParams)
+	s.observe("Patch", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error {
+	start := time.Now()
+	err := s.next.Delete(ctx, id, deleteMovieParams)
+	s.observe("Delete", start, err)
+	return err
+}
+
+// Batch records the batch as a whole, the errors of its operations are part
+// of the result rather than a failure of the call.
+func (s *InstrumentedStore) Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error) {
+	start := time.Now()
+	results, err := s.next.Batch(ctx, operations, atomic)
+	s.observe("Batch", start, err)
+	return results, err
+}
+
+func (s *InstrumentedStore) WithTx(ctx context.Context, fn func(tx Interface) error) error {
+	start := time.Now()
+	err := s.next.WithTx(ctx, func(tx Interface) error {
+		return fn(&InstrumentedStore{next: tx, metrics: s.metrics})
+	})
+	s.observe("WithTx", start, err)
+	return err
+}
+
+func (s *InstrumentedStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Ping(ctx)
+	s.observe("Ping", start, err)
+	return err
+}
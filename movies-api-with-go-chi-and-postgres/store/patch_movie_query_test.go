@@ -0,0 +1,47 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildPatchMovieQuery(t *testing.T) {
+	columns := movieColumns{
+		ID:          "Id",
+		Title:       "Title",
+		Director:    "Director",
+		ReleaseDate: "ReleaseDate",
+		TicketPrice: "TicketPrice",
+		CreatedAt:   "CreatedAt",
+		UpdatedAt:   "UpdatedAt",
+		Version:     "Version",
+	}
+	id := uuid.New()
+	updatedAt := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	title := "Patched"
+	ticketPrice := 9.5
+
+	t.Run("given some fields, should only set those fields", func(t *testing.T) {
+		set, where, args := buildPatchMovieQuery(columns, id, PatchMovieParams{Title: &title, TicketPrice: &ticketPrice}, updatedAt)
+
+		assert.Equal(t, "SET Title = :title, TicketPrice = :ticket_price, UpdatedAt = :updated_at, Version = Version + 1", set)
+		assert.Equal(t, "WHERE Id = :id", where)
+		assert.Equal(t, map[string]any{
+			"title":        title,
+			"ticket_price": ticketPrice,
+			"updated_at":   updatedAt,
+			"id":           id,
+		}, args)
+	})
+
+	t.Run("given expected version, should match version", func(t *testing.T) {
+		set, where, args := buildPatchMovieQuery(columns, id, PatchMovieParams{ExpectedVersion: 3}, updatedAt)
+
+		assert.Equal(t, "SET UpdatedAt = :updated_at, Version = Version + 1", set)
+		assert.Equal(t, "WHERE Id = :id AND Version = :version", where)
+		assert.Equal(t, int64(3), args["version"])
+	})
+}
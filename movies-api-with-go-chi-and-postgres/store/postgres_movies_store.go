@@ -3,54 +3,89 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx"
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-postgres/config"
 )
 
 const driverName = "pgx"
 
-type PostgresMoviesStore struct {
-	databaseUrl string
-	dbx         *sqlx.DB
-}
+// PostgresDriver is the STORE_DRIVER name of PostgresMoviesStore.
+const PostgresDriver = "postgres"
 
-func NewPostgresMoviesStore(databaseUrl string) *PostgresMoviesStore {
-	return &PostgresMoviesStore{
-		databaseUrl: databaseUrl,
-	}
+func init() {
+	Register(PostgresDriver, Driver{
+		Validate: validatePostgresConfig,
+		Open: func(ctx context.Context, config config.Database) (Closer, error) {
+			s, err := NewPostgresMoviesStore(ctx, config)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	})
 }
 
-func (s *PostgresMoviesStore) connect(ctx context.Context) error {
-	dbx, err := sqlx.ConnectContext(ctx, driverName, s.databaseUrl)
-	if err != nil {
+func validatePostgresConfig(config config.Database) error {
+	if err := requireDatabaseURL(config); err != nil {
 		return err
 	}
-
-	s.dbx = dbx
+	if _, err := pgx.ParseConnectionString(config.DatabaseURL); err != nil {
+		return fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
 	return nil
 }
 
-func (s *PostgresMoviesStore) close() error {
-	return s.dbx.Close()
+type PostgresMoviesStore struct {
+	db *sqlx.DB
+	// dbx is db, or the transaction the store is bound to by inTx
+	dbx sqlxExecutor
 }
 
-func (s *PostgresMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
-	err := s.connect(ctx)
+func NewPostgresMoviesStore(ctx context.Context, config config.Database) (*PostgresMoviesStore, error) {
+	dbx, err := sqlx.ConnectContext(ctx, driverName, config.DatabaseURL)
 	if err != nil {
 		return nil, err
 	}
-	defer s.close()
 
+	dbx.SetMaxOpenConns(config.MaxOpenConnections)
+	dbx.SetMaxIdleConns(config.MaxIdleConnections)
+	dbx.SetConnMaxLifetime(config.ConnectionMaxLifetime)
+	dbx.SetConnMaxIdleTime(config.ConnectionMaxIdleTime)
+
+	return &PostgresMoviesStore{
+		db:  dbx,
+		dbx: tracedExecutor{dbx},
+	}, nil
+}
+
+func (s *PostgresMoviesStore) Close() error {
+	return s.db.Close()
+}
+
+// DB returns the connection pool of the store, e.g. to collect its stats.
+func (s *PostgresMoviesStore) DB() *sql.DB {
+	return s.db.DB
+}
+
+func (s *PostgresMoviesStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *PostgresMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
 	var movies []Movie
 	if err := s.dbx.SelectContext(
 		ctx,
 		&movies,
 		`SELECT
-			id, title, director, release_date, ticket_price, created_at, updated_at
+			id, title, director, release_date, ticket_price, created_at, updated_at, version
 		FROM movies`); err != nil {
 		return nil, err
 	}
@@ -58,19 +93,97 @@ func (s *PostgresMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
 	return movies, nil
 }
 
-func (s *PostgresMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
-	err := s.connect(ctx)
+var postgresMovieColumns = movieColumns{
+	ID:          "id",
+	SortID:      "id",
+	Title:       "title",
+	SortTitle:   `title COLLATE "C"`,
+	Director:    "director",
+	ReleaseDate: "release_date",
+	TicketPrice: "ticket_price",
+	CreatedAt:   "created_at",
+	UpdatedAt:   "updated_at",
+	Version:     "version",
+}
+
+func (s *PostgresMoviesStore) List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error) {
+	where, orderBy, args, err := buildListMoviesQuery(postgresMovieColumns, listMoviesParams)
+	if err != nil {
+		return MoviesPage{}, err
+	}
+
+	limit := ""
+	if listMoviesParams.Limit > 0 {
+		limit = "LIMIT :limit"
+		args["limit"] = listMoviesParams.Limit + 1
+	}
+
+	query, queryArgs, err := s.dbx.BindNamed(
+		`SELECT
+			id, title, director, release_date, ticket_price, created_at, updated_at, version
+		FROM movies
+		`+where+`
+		`+orderBy+`
+		`+limit,
+		args)
 	if err != nil {
-		return Movie{}, err
+		return MoviesPage{}, err
+	}
+
+	var movies []Movie
+	if err := s.dbx.SelectContext(ctx, &movies, query, queryArgs...); err != nil {
+		return MoviesPage{}, err
 	}
-	defer s.close()
 
+	return nextPage(movies, listMoviesParams.Limit), nil
+}
+
+// Search matches the search terms as prefixes against the weighted search
+// vector of title and director, ranking results with ts_rank.
+func (s *PostgresMoviesStore) Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error) {
+	terms, err := parseSearchTerms(searchMoviesParams.Query)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+
+	args := []any{strings.Join(terms, " & ")}
+	limit := ""
+	if searchMoviesParams.Limit > 0 {
+		limit = "LIMIT $2"
+		args = append(args, searchMoviesParams.Limit)
+	}
+
+	var movies []Movie
+	if err := s.dbx.SelectContext(
+		ctx,
+		&movies,
+		`SELECT
+			id, title, director, release_date, ticket_price, created_at, updated_at, version
+		FROM movies, to_tsquery('simple', $1) query
+		WHERE search @@ query
+		ORDER BY ts_rank(search, query) DESC, title, created_at, id
+		`+limit,
+		args...); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+func (s *PostgresMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
 	var movie Movie
 	if err := s.dbx.GetContext(
 		ctx,
 		&movie,
 		`SELECT
-			id, title, director, release_date, ticket_price, created_at, updated_at
+			id, title, director, release_date, ticket_price, created_at, updated_at, version
 		FROM movies
 		WHERE id = $1`,
 		id); err != nil {
@@ -84,13 +197,17 @@ func (s *PostgresMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie,
 	return movie, nil
 }
 
-func (s *PostgresMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
-	err := s.connect(ctx)
-	if err != nil {
-		return err
-	}
-	defer s.close()
+const (
+	insertMovieQuery = `INSERT INTO movies
+			(id, title, director, release_date, ticket_price, created_at, updated_at, version)
+		VALUES
+			(:id, :title, :director, :release_date, :ticket_price, :created_at, :updated_at, :version)`
+	// movies per INSERT in CreateMany, each takes 8 of the 65535 parameters
+	// postgres allows in a statement
+	createManyBatchSize = 1000
+)
 
+func (s *PostgresMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
 	movie := Movie{
 		ID:          createMovieParams.ID,
 		Title:       createMovieParams.Title,
@@ -99,15 +216,10 @@ func (s *PostgresMoviesStore) Create(ctx context.Context, createMovieParams Crea
 		TicketPrice: createMovieParams.TicketPrice,
 		CreatedAt:   time.Now().UTC(),
 		UpdatedAt:   time.Now().UTC(),
+		Version:     1,
 	}
 
-	if _, err := s.dbx.NamedExecContext(
-		ctx,
-		`INSERT INTO movies
-			(id, title, director, release_date, ticket_price, created_at, updated_at)
-		VALUES
-			(:id, :title, :director, :release_date, :ticket_price, :created_at, :updated_at)`,
-		movie); err != nil {
+	if _, err := s.dbx.NamedExecContext(ctx, insertMovieQuery, movie); err != nil {
 		if strings.Contains(err.Error(), "SQLSTATE 23505") {
 			return &DuplicateKeyError{ID: createMovieParams.ID}
 		}
@@ -117,13 +229,48 @@ func (s *PostgresMoviesStore) Create(ctx context.Context, createMovieParams Crea
 	return nil
 }
 
-func (s *PostgresMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
-	err := s.connect(ctx)
-	if err != nil {
-		return err
+func (s *PostgresMoviesStore) CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error {
+	if id, ok := duplicateMovieID(createMoviesParams); ok {
+		return &DuplicateKeyError{ID: id}
+	}
+
+	now := time.Now().UTC()
+	movies := make([]Movie, 0, len(createMoviesParams))
+	for _, p := range createMoviesParams {
+		movies = append(movies, Movie{
+			ID:          p.ID,
+			Title:       p.Title,
+			Director:    p.Director,
+			ReleaseDate: p.ReleaseDate,
+			TicketPrice: p.TicketPrice,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			Version:     1,
+		})
 	}
-	defer s.close()
 
+	return s.inTx(ctx, func(tx *PostgresMoviesStore) error {
+		for start := 0; start < len(movies); start += createManyBatchSize {
+			end := start + createManyBatchSize
+			if end > len(movies) {
+				end = len(movies)
+			}
+
+			if _, err := tx.dbx.NamedExecContext(ctx, insertMovieQuery, movies[start:end]); err != nil {
+				var pgErr pgx.PgError
+				if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+					if id, ok := reportedMovieID(pgErr.Detail, createMoviesParams); ok {
+						return &DuplicateKeyError{ID: id}
+					}
+				}
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PostgresMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
 	movie := Movie{
 		ID:          id,
 		Title:       updateMovieParams.Title,
@@ -131,33 +278,133 @@ func (s *PostgresMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMo
 		ReleaseDate: updateMovieParams.ReleaseDate,
 		TicketPrice: updateMovieParams.TicketPrice,
 		UpdatedAt:   time.Now().UTC(),
+		Version:     updateMovieParams.ExpectedVersion,
 	}
 
-	if _, err := s.dbx.NamedExecContext(
-		ctx,
-		`UPDATE movies
-		SET title = :title, director = :director, release_date = :release_date, ticket_price = :ticket_price, updated_at = :updated_at
-		WHERE id = :id`,
-		movie); err != nil {
+	query := `UPDATE movies
+		SET title = :title, director = :director, release_date = :release_date, ticket_price = :ticket_price, updated_at = :updated_at, version = version + 1
+		WHERE id = :id`
+	if updateMovieParams.ExpectedVersion > 0 {
+		query += ` AND version = :version`
+	}
+
+	result, err := s.dbx.NamedExecContext(ctx, query, movie)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
 		return err
 	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, updateMovieParams.ExpectedVersion)
+	}
 
 	return nil
 }
 
-func (s *PostgresMoviesStore) Delete(ctx context.Context, id uuid.UUID) error {
-	err := s.connect(ctx)
+func (s *PostgresMoviesStore) Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error {
+	set, where, args := buildPatchMovieQuery(postgresMovieColumns, id, patchMovieParams, time.Now().UTC())
+	query := `UPDATE movies
+		` + set + `
+		` + where
+
+	result, err := s.dbx.NamedExecContext(ctx, query, args)
 	if err != nil {
 		return err
 	}
-	defer s.close()
 
-	if _, err := s.dbx.ExecContext(
-		ctx,
-		`DELETE FROM movies
-		WHERE id = $1`, id); err != nil {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, patchMovieParams.ExpectedVersion)
+	}
+
+	return nil
+}
+
+func (s *PostgresMoviesStore) Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error {
+	query := `DELETE FROM movies
+		WHERE id = $1`
+	args := []any{id}
+	if deleteMovieParams.ExpectedVersion > 0 {
+		query += ` AND version = $2`
+		args = append(args, deleteMovieParams.ExpectedVersion)
+	}
+
+	result, err := s.dbx.ExecContext(ctx, query, args...)
+	if err != nil {
 		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, deleteMovieParams.ExpectedVersion)
+	}
+
 	return nil
 }
+
+func (s *PostgresMoviesStore) Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error) {
+	return batch(ctx, s, operations, atomic)
+}
+
+func (s *PostgresMoviesStore) WithTx(ctx context.Context, fn func(tx Interface) error) error {
+	return s.inTx(ctx, func(tx *PostgresMoviesStore) error {
+		return fn(tx)
+	})
+}
+
+// inTx runs fn with a copy of the store bound to a transaction, which is
+// committed if fn succeeds. A store already bound to one reuses it.
+func (s *PostgresMoviesStore) inTx(ctx context.Context, fn func(tx *PostgresMoviesStore) error) error {
+	if boundToTx(s.dbx) {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&PostgresMoviesStore{db: s.db, dbx: tracedExecutor{tx}}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// noRowsAffectedError tells apart a missing movie from a version mismatch
+// when a conditional write did not affect any rows.
+func (s *PostgresMoviesStore) noRowsAffectedError(ctx context.Context, id uuid.UUID, expectedVersion int64) error {
+	if expectedVersion == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	var count int
+	if err := s.dbx.GetContext(
+		ctx,
+		&count,
+		`SELECT COUNT(*) FROM movies
+		WHERE id = $1`,
+		id); err != nil {
+		return err
+	}
+	if count == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	return &VersionMismatchError{ID: id, ExpectedVersion: expectedVersion}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+)
+
+// stubExecutor succeeds without running its statements.
+type stubExecutor struct {
+	sqlxExecutor
+}
+
+func (stubExecutor) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return nil
+}
+
+func (stubExecutor) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	return nil, nil
+}
+
+func TestTracedExecutor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+	sut := tracedExecutor{stubExecutor{}}
+
+	t.Run("should record the statement on the span in the context", func(t *testing.T) {
+		ctx, span := tracer.Start(context.Background(), "store.GetByID")
+		require.NoError(t, sut.SelectContext(ctx, nil, "SELECT id FROM movies WHERE id = $1", "id"))
+		span.End()
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 1)
+		assert.Contains(t, spans[0].Attributes(), semconv.DBStatement("SELECT id FROM movies WHERE id = $1"))
+	})
+
+	t.Run("should record the last of several statements", func(t *testing.T) {
+		ctx, span := tracer.Start(context.Background(), "store.Update")
+		require.NoError(t, sut.SelectContext(ctx, nil, "SELECT version FROM movies WHERE id = $1", "id"))
+		_, err := sut.NamedExecContext(ctx, "UPDATE movies SET title = :title WHERE id = :id", nil)
+		require.NoError(t, err)
+		span.End()
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 2)
+		assert.Contains(t, spans[1].Attributes(), semconv.DBStatement("UPDATE movies SET title = :title WHERE id = :id"))
+	})
+
+	t.Run("should tell apart transactions", func(t *testing.T) {
+		assert.True(t, boundToTx(tracedExecutor{&sqlx.Tx{}}))
+		assert.False(t, boundToTx(sut))
+	})
+}
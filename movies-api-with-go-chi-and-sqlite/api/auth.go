@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/auth"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/store"
+)
+
+// authenticate puts the principal of a request to the movie routes on its
+// context. Writes need credentials, reads only if the authenticator requires
+// them, but credentials that fail verification are rejected either way.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authenticator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := s.authenticator.Authenticate(r)
+		if err != nil {
+			if errors.Is(err, auth.ErrNoCredentials) && isRead(r) && !s.authenticator.RequireReads() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="movies-api"`)
+			renderError(w, r, ProblemUnauthorized.New(err))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+	})
+}
+
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// authorized reports whether the caller of r was granted scope. Anonymous
+// callers, only let through for reads, may read, and every caller is granted
+// every scope without an authenticator.
+func (s *Server) authorized(r *http.Request, scope string) bool {
+	if s.authenticator == nil {
+		return true
+	}
+
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		return scope == auth.ScopeRead
+	}
+	return principal.HasScope(scope)
+}
+
+// authorize only lets requests through if their caller was granted scope, it
+// must run after authenticate.
+func (s *Server) authorize(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.authorized(r, scope) {
+				renderError(w, r, forbidden(scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func forbidden(scope string) *Problem {
+	return ProblemForbidden.New(fmt.Errorf("%s scope required", scope))
+}
+
+// writeMovie runs write in a transaction with expectedVersion and returns the
+// version it wrote movie id at, read back in the same transaction. Unless the
+// caller was granted auth.ScopePrice, write is refused when ticketPrice
+// changes the price of the movie. Reading the movie does not lock it under
+// READ COMMITTED, so without an expectedVersion write is made conditional on
+// the version the price was compared at and fails with a version mismatch if
+// it changed since.
+func (s *Server) writeMovie(r *http.Request, id uuid.UUID, ticketPrice *float64, expectedVersion int64, write func(tx store.Interface, expectedVersion int64) error) (int64, error) {
+	var version int64
+	err := s.store.WithTx(r.Context(), func(tx store.Interface) error {
+		if ticketPrice != nil && !s.authorized(r, auth.ScopePrice) {
+			movie, err := tx.GetByID(r.Context(), id)
+			if err != nil {
+				return err
+			}
+			if movie.TicketPrice != *ticketPrice {
+				return forbidden(auth.ScopePrice)
+			}
+			if expectedVersion == 0 {
+				expectedVersion = movie.Version
+			}
+		}
+
+		if err := write(tx, expectedVersion); err != nil {
+			return err
+		}
+		movie, err := tx.GetByID(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		version = movie.Version
+		return nil
+	})
+	return version, err
+}
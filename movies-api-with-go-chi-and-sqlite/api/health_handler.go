@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+const (
+	healthStatusUp   = "up"
+	healthStatusDown = "down"
+)
+
+type healthResponse struct {
+	OK bool `json:"ok"`
+}
+
+func (hr healthResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// handleGetHealth is kept for clients of /health, like /health/live it only
+// reports the server is running.
+func (s *Server) handleGetHealth(w http.ResponseWriter, r *http.Request) {
+	health := healthResponse{OK: true}
+	render.Render(w, r, health)
+}
+
+type componentHealth struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type probeResponse struct {
+	Status       string                     `json:"status"`
+	ShuttingDown bool                       `json:"shutting_down,omitempty"`
+	Components   map[string]componentHealth `json:"components,omitempty"`
+}
+
+func (pr probeResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// handleGetLive reports the server is running, it does not check any
+// dependency so a failing database does not get the server restarted.
+func (s *Server) handleGetLive(w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, probeResponse{Status: healthStatusUp})
+}
+
+// handleGetReady reports whether the server should receive traffic, it is down
+// if a component fails its check within ReadinessTimeout or once the server
+// has started shutting down.
+func (s *Server) handleGetReady(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if s.cfg.ReadinessTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.ReadinessTimeout)
+		defer cancel()
+	}
+
+	ready := probeResponse{
+		Status: healthStatusUp,
+		Components: map[string]componentHealth{
+			"store": checkComponent(ctx, s.store.Ping),
+		},
+	}
+	for _, component := range ready.Components {
+		if component.Status != healthStatusUp {
+			ready.Status = healthStatusDown
+		}
+	}
+	if s.shuttingDown.Load() {
+		ready.Status = healthStatusDown
+		ready.ShuttingDown = true
+	}
+
+	if ready.Status != healthStatusUp {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.Render(w, r, ready)
+}
+
+// checkComponent runs check and reports its outcome and latency.
+func checkComponent(ctx context.Context, check func(ctx context.Context) error) componentHealth {
+	start := time.Now()
+	err := check(ctx)
+	health := componentHealth{
+		Status:    healthStatusUp,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		health.Status = healthStatusDown
+		health.Error = err.Error()
+	}
+	return health
+}
@@ -0,0 +1,503 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+type movieResponse struct {
+	ID          uuid.UUID `json:"id"`
+	Title       string    `json:"title"`
+	Director    string    `json:"director"`
+	ReleaseDate time.Time `json:"release_date"`
+	TicketPrice float64   `json:"ticket_price"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int64     `json:"version"`
+}
+
+func NewMovieResponse(m store.Movie) movieResponse {
+	return movieResponse{
+		ID:          m.ID,
+		Title:       m.Title,
+		Director:    m.Director,
+		ReleaseDate: m.ReleaseDate,
+		TicketPrice: m.TicketPrice,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
+		Version:     m.Version,
+	}
+}
+
+func (hr movieResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewMovieListResponse(movies []store.Movie) []render.Renderer {
+	list := []render.Renderer{}
+	for _, movie := range movies {
+		mr := NewMovieResponse(movie)
+		list = append(list, mr)
+	}
+	return list
+}
+
+const (
+	defaultListMoviesLimit   = 20
+	maxListMoviesLimit       = 100
+	defaultSearchMoviesLimit = 10
+	maxSearchMoviesLimit     = 50
+)
+
+var sortFields = map[string]store.SortField{
+	"created_at":   store.SortByCreatedAt,
+	"title":        store.SortByTitle,
+	"release_date": store.SortByReleaseDate,
+	"ticket_price": store.SortByTicketPrice,
+}
+
+func parseLimit(query url.Values, defaultLimit int, maxLimit int) (int, error) {
+	limit := query.Get("limit")
+	if limit == "" {
+		return defaultLimit, nil
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 || n > maxLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	return n, nil
+}
+
+func parseListMoviesParams(r *http.Request) (store.ListMoviesParams, error) {
+	query := r.URL.Query()
+	params := store.ListMoviesParams{
+		SortBy:   store.SortByCreatedAt,
+		Director: query.Get("director"),
+	}
+
+	limit, err := parseLimit(query, defaultListMoviesLimit, maxListMoviesLimit)
+	if err != nil {
+		return params, err
+	}
+	params.Limit = limit
+
+	if sort := query.Get("sort"); sort != "" {
+		field := strings.TrimPrefix(sort, "-")
+		sortBy, ok := sortFields[field]
+		if !ok {
+			return params, fmt.Errorf("unsupported sort: %s", sort)
+		}
+		params.SortBy = sortBy
+		params.Descending = strings.HasPrefix(sort, "-")
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(query.Get("sort"), cursor)
+		if err != nil {
+			return params, fmt.Errorf("invalid cursor: %w", err)
+		}
+		params.After = after
+	}
+
+	for name, target := range map[string]**time.Time{
+		"release_date_from": &params.ReleaseDateFrom,
+		"release_date_to":   &params.ReleaseDateTo,
+	} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return params, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			t = t.UTC()
+			*target = &t
+		}
+	}
+
+	for name, target := range map[string]**float64{
+		"min_ticket_price": &params.MinTicketPrice,
+		"max_ticket_price": &params.MaxTicketPrice,
+	} {
+		if value := query.Get(name); value != "" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return params, fmt.Errorf("%s must be a number", name)
+			}
+			*target = &f
+		}
+	}
+
+	return params, nil
+}
+
+func (s *Server) handleListMovies(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListMoviesParams(r)
+	if err != nil {
+		renderError(w, r, ProblemBadRequest.New(err))
+		return
+	}
+
+	page, err := s.store.List(r.Context(), params)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	if page.Next != nil {
+		query := r.URL.Query()
+		cursor, err := encodeCursor(query.Get("sort"), page.Next)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		query.Set("cursor", cursor)
+		next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+	}
+
+	render.RenderList(w, r, NewMovieListResponse(page.Movies))
+}
+
+func (s *Server) handleSearchMovies(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		renderError(w, r, ProblemBadRequest.New(errors.New("q is required")))
+		return
+	}
+	if store.SearchTermCount(q) > store.MaxSearchTerms {
+		renderError(w, r, ProblemBadRequest.New(fmt.Errorf("q must have at most %d words", store.MaxSearchTerms)))
+		return
+	}
+
+	limit, err := parseLimit(query, defaultSearchMoviesLimit, maxSearchMoviesLimit)
+	if err != nil {
+		renderError(w, r, ProblemBadRequest.New(err))
+		return
+	}
+
+	movies, err := s.store.Search(r.Context(), store.SearchMoviesParams{Query: q, Limit: limit})
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.RenderList(w, r, NewMovieListResponse(movies))
+}
+
+func (s *Server) handleGetMovie(w http.ResponseWriter, r *http.Request) {
+	idParam := chi.URLParam(r, "id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		renderError(w, r, ProblemBadRequest.New(fmt.Errorf("invalid movie id: %w", err)))
+		return
+	}
+
+	movie, err := s.store.GetByID(r.Context(), id)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", movieETag(movie.Version))
+	mr := NewMovieResponse(movie)
+	render.Render(w, r, mr)
+}
+
+type CreateMovieRequest struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Director    string    `json:"director"`
+	ReleaseDate time.Time `json:"release_date"`
+	TicketPrice float64   `json:"ticket_price"`
+
+	id uuid.UUID
+}
+
+func (mr *CreateMovieRequest) Bind(r *http.Request) error {
+	v := &validator{}
+
+	if mr.ID == "" {
+		mr.id = uuid.New()
+	} else {
+		id, err := uuid.Parse(mr.ID)
+		v.check(err == nil, "id", "must be a valid UUID")
+		mr.id = id
+	}
+	v.checkText(mr.Title, "title", maxTitleLength)
+	v.checkText(mr.Director, "director", maxDirectorLength)
+	v.checkReleaseDate(mr.ReleaseDate, "release_date")
+	v.checkTicketPrice(mr.TicketPrice, "ticket_price")
+
+	return v.err()
+}
+
+func (s *Server) handleCreateMovie(w http.ResponseWriter, r *http.Request) {
+	data := &CreateMovieRequest{}
+	if err := render.Bind(r, data); err != nil {
+		renderBindError(w, r, err)
+		return
+	}
+
+	createMovieParams := store.CreateMovieParams{
+		ID:          data.id,
+		Title:       data.Title,
+		Director:    data.Director,
+		ReleaseDate: data.ReleaseDate,
+		TicketPrice: data.TicketPrice,
+	}
+	err := s.store.Create(r.Context(), createMovieParams)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/movies/%s", createMovieParams.ID))
+	// stores create every movie at version 1
+	w.Header().Set("ETag", movieETag(1))
+	w.WriteHeader(200)
+	w.Write(nil)
+}
+
+type updateMovieRequest struct {
+	Title       string    `json:"title"`
+	Director    string    `json:"director"`
+	ReleaseDate time.Time `json:"release_date"`
+	TicketPrice float64   `json:"ticket_price"`
+}
+
+func (mr *updateMovieRequest) Bind(r *http.Request) error {
+	v := &validator{}
+
+	v.checkText(mr.Title, "title", maxTitleLength)
+	v.checkText(mr.Director, "director", maxDirectorLength)
+	v.checkReleaseDate(mr.ReleaseDate, "release_date")
+	v.checkTicketPrice(mr.TicketPrice, "ticket_price")
+
+	return v.err()
+}
+
+// renderBindError renders validation errors from Bind as 422 and anything
+// else, e.g. malformed JSON, as a bad request.
+func renderBindError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		err = ProblemBadRequest.New(err)
+	}
+	renderError(w, r, err)
+}
+
+func (s *Server) handleUpdateMovie(w http.ResponseWriter, r *http.Request) {
+	idParam := chi.URLParam(r, "id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		renderError(w, r, ProblemBadRequest.New(fmt.Errorf("invalid movie id: %w", err)))
+		return
+	}
+
+	expectedVersion, err := parseIfMatch(r)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := &updateMovieRequest{}
+	if err := render.Bind(r, data); err != nil {
+		renderBindError(w, r, err)
+		return
+	}
+
+	updateMovieParams := store.UpdateMovieParams{
+		Title:       data.Title,
+		Director:    data.Director,
+		ReleaseDate: data.ReleaseDate,
+		TicketPrice: data.TicketPrice,
+	}
+	version, err := s.writeMovie(r, id, &data.TicketPrice, expectedVersion, func(tx store.Interface, expectedVersion int64) error {
+		updateMovieParams.ExpectedVersion = expectedVersion
+		return tx.Update(r.Context(), id, updateMovieParams)
+	})
+	if err != nil {
+		renderError(w, r, ifMatchAnyError(r, err))
+		return
+	}
+
+	w.Header().Set("ETag", movieETag(version))
+	w.WriteHeader(200)
+	w.Write(nil)
+}
+
+const mergePatchContentType = "application/merge-patch+json"
+
+// patchMovieRequest is a JSON merge patch (RFC 7386) of a movie, fields left
+// out of the patch are nil and keep their current value.
+type patchMovieRequest struct {
+	Title       *string
+	Director    *string
+	ReleaseDate *time.Time
+	TicketPrice *float64
+}
+
+// decode reads the merge patch from body, a malformed document is returned as
+// is while fields that cannot be patched are returned as a ValidationError.
+func (mr *patchMovieRequest) decode(body io.Reader) error {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&fields); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	v := &validator{}
+	for _, name := range names {
+		value := fields[name]
+		// null removes a member in a merge patch, every movie field is required
+		if string(value) == "null" {
+			v.check(false, name, "must not be null")
+			continue
+		}
+
+		switch name {
+		case "title":
+			mr.Title = new(string)
+			if v.decode(value, mr.Title, name, "must be a string") {
+				v.checkText(*mr.Title, name, maxTitleLength)
+			}
+		case "director":
+			mr.Director = new(string)
+			if v.decode(value, mr.Director, name, "must be a string") {
+				v.checkText(*mr.Director, name, maxDirectorLength)
+			}
+		case "release_date":
+			mr.ReleaseDate = new(time.Time)
+			if v.decode(value, mr.ReleaseDate, name, "must be an RFC 3339 timestamp") {
+				v.checkReleaseDate(*mr.ReleaseDate, name)
+			}
+		case "ticket_price":
+			mr.TicketPrice = new(float64)
+			if v.decode(value, mr.TicketPrice, name, "must be a number") {
+				v.checkTicketPrice(*mr.TicketPrice, name)
+			}
+		default:
+			v.check(false, name, "cannot be patched")
+		}
+	}
+
+	return v.err()
+}
+
+// empty reports whether the patch leaves every field as is.
+func (mr *patchMovieRequest) empty() bool {
+	return mr.Title == nil && mr.Director == nil && mr.ReleaseDate == nil && mr.TicketPrice == nil
+}
+
+// handleEmptyPatch responds to a patch changing nothing with the current ETag
+// of the movie, without writing a new version of it.
+func (s *Server) handleEmptyPatch(w http.ResponseWriter, r *http.Request, id uuid.UUID, expectedVersion int64) {
+	movie, err := s.store.GetByID(r.Context(), id)
+	if err != nil {
+		renderError(w, r, ifMatchAnyError(r, err))
+		return
+	}
+	if expectedVersion > 0 && movie.Version != expectedVersion {
+		renderError(w, r, &store.VersionMismatchError{ID: id, ExpectedVersion: expectedVersion})
+		return
+	}
+
+	w.Header().Set("ETag", movieETag(movie.Version))
+	w.WriteHeader(200)
+	w.Write(nil)
+}
+
+func (s *Server) handlePatchMovie(w http.ResponseWriter, r *http.Request) {
+	idParam := chi.URLParam(r, "id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		renderError(w, r, ProblemBadRequest.New(fmt.Errorf("invalid movie id: %w", err)))
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != mergePatchContentType {
+		renderError(w, r, ProblemUnsupportedMedia.New(fmt.Errorf("content type must be %s", mergePatchContentType)))
+		return
+	}
+
+	expectedVersion, err := parseIfMatch(r)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := &patchMovieRequest{}
+	if err := data.decode(r.Body); err != nil {
+		renderBindError(w, r, err)
+		return
+	}
+
+	if data.empty() {
+		s.handleEmptyPatch(w, r, id, expectedVersion)
+		return
+	}
+
+	patchMovieParams := store.PatchMovieParams{
+		Title:       data.Title,
+		Director:    data.Director,
+		ReleaseDate: data.ReleaseDate,
+		TicketPrice: data.TicketPrice,
+	}
+	version, err := s.writeMovie(r, id, data.TicketPrice, expectedVersion, func(tx store.Interface, expectedVersion int64) error {
+		patchMovieParams.ExpectedVersion = expectedVersion
+		return tx.Patch(r.Context(), id, patchMovieParams)
+	})
+	if err != nil {
+		renderError(w, r, ifMatchAnyError(r, err))
+		return
+	}
+
+	w.Header().Set("ETag", movieETag(version))
+	w.WriteHeader(200)
+	w.Write(nil)
+}
+
+func (s *Server) handleDeleteMovie(w http.ResponseWriter, r *http.Request) {
+	idParam := chi.URLParam(r, "id")
+	id, err := uuid.Parse(idParam)
+	if err != nil {
+		renderError(w, r, ProblemBadRequest.New(fmt.Errorf("invalid movie id: %w", err)))
+		return
+	}
+
+	expectedVersion, err := parseIfMatch(r)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	err = s.store.Delete(r.Context(), id, store.DeleteMovieParams{ExpectedVersion: expectedVersion})
+	if err != nil {
+		renderError(w, r, ifMatchAnyError(r, err))
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(nil)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/auth"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/ratelimit"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Server struct {
+	cfg         config.HTTPServer
+	store       store.Interface
+	router      *chi.Mux
+	metrics     *prometheus.Registry
+	httpMetrics *httpMetrics
+	logger      *slog.Logger
+	// authenticator is nil if authentication is disabled
+	authenticator *auth.Authenticator
+	// limiter is nil if rate limiting is disabled
+	limiter *ratelimit.Limiter
+	// shuttingDown is set once Start received a shutdown signal
+	shuttingDown atomic.Bool
+}
+
+// NewServer returns a server for store, it registers its HTTP metrics in
+// metrics and serves everything registered there on /metrics. Requests and
+// errors are logged to logger. The movie routes are open to anyone if
+// authenticator is nil and not rate limited if limiter is nil.
+func NewServer(cfg config.HTTPServer, store store.Interface, metrics *prometheus.Registry, logger *slog.Logger, authenticator *auth.Authenticator, limiter *ratelimit.Limiter) *Server {
+	srv := &Server{
+		cfg:           cfg,
+		store:         store,
+		router:        chi.NewRouter(),
+		metrics:       metrics,
+		httpMetrics:   newHTTPMetrics(metrics),
+		logger:        logger,
+		authenticator: authenticator,
+		limiter:       limiter,
+	}
+
+	srv.routes()
+
+	return srv
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *Server) Start(ctx context.Context) {
+	server := http.Server{
+		Addr:         fmt.Sprintf(":%d", s.cfg.Port),
+		Handler:      s.router,
+		IdleTimeout:  s.cfg.IdleTimeout,
+		ReadTimeout:  s.cfg.ReadTimeout,
+		WriteTimeout: s.cfg.WriteTimeout,
+		ErrorLog:     slog.NewLogLogger(s.logger.Handler(), slog.LevelError),
+	}
+
+	shutdownComplete := handleShutdown(func() {
+		s.shuttingDown.Store(true)
+		time.Sleep(s.cfg.ShutdownDelay)
+		if err := server.Shutdown(ctx); err != nil {
+			s.logger.Error("server.Shutdown failed", slog.Any("error", err))
+		}
+	})
+
+	if err := server.ListenAndServe(); err == http.ErrServerClosed {
+		<-shutdownComplete
+	} else {
+		s.logger.Error("http.ListenAndServe failed", slog.Any("error", err))
+	}
+
+	s.logger.Info("Shutdown gracefully")
+}
+
+func handleShutdown(onShutdownSignal func()) <-chan struct{} {
+	shutdown := make(chan struct{})
+
+	go func() {
+		shutdownSignal := make(chan os.Signal, 1)
+		signal.Notify(shutdownSignal, os.Interrupt, syscall.SIGTERM)
+
+		<-shutdownSignal
+
+		onShutdownSignal()
+		close(shutdown)
+	}()
+
+	return shutdown
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// apiKeys maps the SHA-256 of every key to its name, only the hashes are kept
+// so the configuration does not hold usable keys.
+type apiKeys map[[sha256.Size]byte]string
+
+// loadAPIKeys parses the name:hash pairs of entries and of the lines of file,
+// blank lines and lines starting with # are skipped.
+func loadAPIKeys(entries []string, file string) (apiKeys, error) {
+	keys := apiKeys{}
+	for _, entry := range entries {
+		if err := keys.add(entry); err != nil {
+			return nil, fmt.Errorf("AUTH_API_KEYS: %w", err)
+		}
+	}
+
+	if file == "" {
+		return keys, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if err := keys.add(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (k apiKeys) add(entry string) error {
+	name, hash, ok := strings.Cut(strings.TrimSpace(entry), ":")
+	if !ok || name == "" {
+		return fmt.Errorf("API key must be name:hash")
+	}
+
+	var sum [sha256.Size]byte
+	if len(hash) != hex.EncodedLen(sha256.Size) {
+		return fmt.Errorf("hash of API key %s must be a hex encoded SHA-256", name)
+	}
+	if _, err := hex.Decode(sum[:], []byte(hash)); err != nil {
+		return fmt.Errorf("hash of API key %s must be a hex encoded SHA-256", name)
+	}
+	if _, ok := k[sum]; ok {
+		return fmt.Errorf("API key %s is listed twice", name)
+	}
+
+	k[sum] = name
+	return nil
+}
+
+// lookup returns the name of key, comparing hashes keeps the lookup time
+// independent of how much of the key matches.
+func (k apiKeys) lookup(key string) (string, bool) {
+	name, ok := k[sha256.Sum256([]byte(key))]
+	return name, ok
+}
@@ -0,0 +1,368 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/auth"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	issuer   = "https://issuer.example.com"
+	audience = "movies-api"
+	secret   = "a-secret-long-enough-for-hs256-tokens"
+)
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRequest(header string, value string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	return r
+}
+
+func bearer(token string) *http.Request {
+	return newRequest("Authorization", "Bearer "+token)
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "user-1",
+		"iss": issuer,
+		"aud": audience,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func signHS256(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return token
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func jwksJSON(t *testing.T, keys map[string]*rsa.PrivateKey) []byte {
+	t.Helper()
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func TestAPIKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api-keys")
+	require.NoError(t, os.WriteFile(file, []byte("# deploy keys\n\nci:"+hashAPIKey("ci-key")+"\n"), 0o600))
+
+	sut, err := auth.New(context.Background(), config.Auth{
+		APIKeys:     []string{"admin:" + hashAPIKey("admin-key")},
+		APIKeysFile: file,
+	})
+	require.NoError(t, err)
+
+	t.Run("should authenticate keys from config and file", func(t *testing.T) {
+		principal, err := sut.Authenticate(newRequest(auth.APIKeyHeader, "admin-key"))
+		require.NoError(t, err)
+		assert.Equal(t, &auth.Principal{Subject: "admin", Method: auth.MethodAPIKey, Scopes: auth.AllScopes}, principal)
+
+		principal, err = sut.Authenticate(newRequest(auth.APIKeyHeader, "ci-key"))
+		require.NoError(t, err)
+		assert.Equal(t, "ci", principal.Subject)
+	})
+
+	t.Run("should reject unknown keys", func(t *testing.T) {
+		_, err := sut.Authenticate(newRequest(auth.APIKeyHeader, hashAPIKey("admin-key")))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("should report missing credentials", func(t *testing.T) {
+		_, err := sut.Authenticate(newRequest("", ""))
+		assert.ErrorIs(t, err, auth.ErrNoCredentials)
+	})
+
+	t.Run("should reject bearer tokens without token keys", func(t *testing.T) {
+		_, err := sut.Authenticate(bearer(signHS256(t, validClaims())))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.Auth
+	}{
+		{name: "no credentials", config: config.Auth{}},
+		{name: "key without hash", config: config.Auth{APIKeys: []string{"admin"}}},
+		{name: "plain text key", config: config.Auth{APIKeys: []string{"admin:admin-key"}}},
+		{name: "missing keys file", config: config.Auth{APIKeysFile: filepath.Join(t.TempDir(), "missing")}},
+		{name: "secret without issuer", config: config.Auth{JWTSecret: secret, JWTAudience: audience}},
+		{name: "secret without audience", config: config.Auth{JWTSecret: secret, JWTIssuer: issuer}},
+		{name: "missing JWKS file", config: config.Auth{JWKSFile: filepath.Join(t.TempDir(), "missing"), JWTIssuer: issuer, JWTAudience: audience}},
+		{name: "missing policy file", config: config.Auth{APIKeys: []string{"admin:" + hashAPIKey("admin-key")}, PolicyFile: filepath.Join(t.TempDir(), "missing")}},
+	}
+
+	for _, tc := range tests {
+		t.Run("should reject "+tc.name, func(t *testing.T) {
+			_, err := auth.New(context.Background(), tc.config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestHS256(t *testing.T) {
+	sut, err := auth.New(context.Background(), config.Auth{JWTSecret: secret, JWTIssuer: issuer, JWTAudience: audience})
+	require.NoError(t, err)
+
+	t.Run("should put the claims on the principal", func(t *testing.T) {
+		claims := validClaims()
+		claims["scope"] = "movies:read"
+
+		principal, err := sut.Authenticate(bearer(signHS256(t, claims)))
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", principal.Subject)
+		assert.Equal(t, auth.MethodJWT, principal.Method)
+		assert.Equal(t, "movies:read", principal.Claims["scope"])
+	})
+
+	invalid := map[string]func(claims jwt.MapClaims){
+		"expired":         func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"without expiry":  func(claims jwt.MapClaims) { delete(claims, "exp") },
+		"for an audience": func(claims jwt.MapClaims) { claims["aud"] = "another-api" },
+		"by an issuer":    func(claims jwt.MapClaims) { claims["iss"] = "https://attacker.example.com" },
+		"without subject": func(claims jwt.MapClaims) { delete(claims, "sub") },
+	}
+	for name, modify := range invalid {
+		t.Run("should reject tokens "+name, func(t *testing.T) {
+			claims := validClaims()
+			modify(claims)
+
+			_, err := sut.Authenticate(bearer(signHS256(t, claims)))
+			assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		})
+	}
+
+	t.Run("should reject tokens signed with another secret", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("another-secret"))
+		require.NoError(t, err)
+
+		_, err = sut.Authenticate(bearer(token))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("should reject unsigned tokens", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+
+		_, err = sut.Authenticate(bearer(token))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("should reject other schemes", func(t *testing.T) {
+		_, err := sut.Authenticate(newRequest("Authorization", "Basic dXNlcjpwYXNz"))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+}
+
+func TestRS256(t *testing.T) {
+	key := newRSAKey(t)
+
+	t.Run("should verify tokens with the keys of a JWKS file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(file, jwksJSON(t, map[string]*rsa.PrivateKey{"key-1": key}), 0o600))
+		sut, err := auth.New(context.Background(), config.Auth{JWKSFile: file, JWKSCacheTTL: time.Minute, JWTIssuer: issuer, JWTAudience: audience})
+		require.NoError(t, err)
+
+		principal, err := sut.Authenticate(bearer(signRS256(t, key, "key-1", validClaims())))
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", principal.Subject)
+
+		_, err = sut.Authenticate(bearer(signRS256(t, newRSAKey(t), "key-1", validClaims())))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("should cache the JWKS of a URL", func(t *testing.T) {
+		var fetches atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches.Add(1)
+			w.Write(jwksJSON(t, map[string]*rsa.PrivateKey{"key-1": key}))
+		}))
+		t.Cleanup(server.Close)
+
+		sut, err := auth.New(context.Background(), config.Auth{JWKSURL: server.URL, JWKSCacheTTL: time.Minute, JWTIssuer: issuer, JWTAudience: audience})
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, err := sut.Authenticate(bearer(signRS256(t, key, "key-1", validClaims())))
+			require.NoError(t, err)
+		}
+		_, err = sut.Authenticate(bearer(signRS256(t, newRSAKey(t), "key-2", validClaims())))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+		assert.Equal(t, int32(1), fetches.Load())
+	})
+
+	t.Run("should not accept HS256 tokens signed with the public key", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "jwks.json")
+		data := jwksJSON(t, map[string]*rsa.PrivateKey{"key-1": key})
+		require.NoError(t, os.WriteFile(file, data, 0o600))
+		sut, err := auth.New(context.Background(), config.Auth{JWKSFile: file, JWTIssuer: issuer, JWTAudience: audience})
+		require.NoError(t, err)
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+		token.Header["kid"] = "key-1"
+		signed, err := token.SignedString(data)
+		require.NoError(t, err)
+
+		_, err = sut.Authenticate(bearer(signed))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+}
+
+func writePolicy(t *testing.T, name string, policy string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(policy), 0o600))
+	return file
+}
+
+func TestLoadPolicy(t *testing.T) {
+	t.Run("should load YAML and JSON", func(t *testing.T) {
+		want := &auth.Policy{
+			Roles:    map[string][]string{"viewer": {auth.ScopeRead}},
+			Subjects: map[string][]string{"api_key:ci": {"viewer"}},
+		}
+
+		policy, err := auth.LoadPolicy(writePolicy(t, "policy.yaml", "roles:\n  viewer: [movies:read]\nsubjects:\n  api_key:ci: [viewer]\n"))
+		require.NoError(t, err)
+		assert.Equal(t, want, policy)
+
+		policy, err = auth.LoadPolicy(writePolicy(t, "policy.json", `{"roles":{"viewer":["movies:read"]},"subjects":{"api_key:ci":["viewer"]}}`))
+		require.NoError(t, err)
+		assert.Equal(t, want, policy)
+	})
+
+	invalid := map[string]string{
+		"unknown scopes":        "roles:\n  viewer: [movies:watch]\n",
+		"unknown roles":         "roles:\n  viewer: [movies:read]\nsubjects:\n  api_key:ci: [editor]\n",
+		"unnamespaced subjects": "roles:\n  viewer: [movies:read]\nsubjects:\n  ci: [viewer]\n",
+		"malformed YAML":        "roles: [",
+	}
+	for name, policy := range invalid {
+		t.Run("should reject "+name, func(t *testing.T) {
+			_, err := auth.LoadPolicy(writePolicy(t, "policy.yaml", policy))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPolicyScopes(t *testing.T) {
+	file := writePolicy(t, "policy.yaml", `
+roles:
+  viewer: [movies:read]
+  editor: [movies:read, movies:write, movies:price]
+subjects:
+  api_key:ci: [editor]
+  jwt:user-1: [viewer]
+`)
+	sut, err := auth.New(context.Background(), config.Auth{
+		APIKeys:     []string{"ci:" + hashAPIKey("ci-key"), "guest:" + hashAPIKey("guest-key")},
+		JWTSecret:   secret,
+		JWTIssuer:   issuer,
+		JWTAudience: audience,
+		PolicyFile:  file,
+	})
+	require.NoError(t, err)
+
+	t.Run("should grant the roles of the subject", func(t *testing.T) {
+		principal, err := sut.Authenticate(newRequest(auth.APIKeyHeader, "ci-key"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{auth.ScopePrice, auth.ScopeRead, auth.ScopeWrite}, principal.Scopes)
+		assert.True(t, principal.HasScope(auth.ScopePrice))
+		assert.False(t, principal.HasScope(auth.ScopeDelete))
+	})
+
+	t.Run("should grant nothing to unlisted subjects", func(t *testing.T) {
+		principal, err := sut.Authenticate(newRequest(auth.APIKeyHeader, "guest-key"))
+		require.NoError(t, err)
+		assert.Empty(t, principal.Scopes)
+	})
+
+	t.Run("should grant the roles and scopes of the token", func(t *testing.T) {
+		claims := validClaims()
+		claims["roles"] = []string{"viewer", "unknown"}
+		claims["scope"] = "movies:delete movies:watch"
+
+		principal, err := sut.Authenticate(bearer(signHS256(t, claims)))
+		require.NoError(t, err)
+		assert.Equal(t, []string{auth.ScopeDelete, auth.ScopeRead}, principal.Scopes)
+	})
+
+	t.Run("should grant the roles of the subject of the token", func(t *testing.T) {
+		principal, err := sut.Authenticate(bearer(signHS256(t, validClaims())))
+		require.NoError(t, err)
+		assert.Equal(t, []string{auth.ScopeRead}, principal.Scopes)
+	})
+
+	t.Run("should not grant the roles of an API key to a token claiming its name", func(t *testing.T) {
+		claims := validClaims()
+		claims["sub"] = "ci"
+
+		principal, err := sut.Authenticate(bearer(signHS256(t, claims)))
+		require.NoError(t, err)
+		assert.Empty(t, principal.Scopes)
+	})
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+const envPrefix = ""
+
+type Configuration struct {
+	HTTPServer
+	Database
+	Tracing
+	Logging
+	Auth
+	RateLimit
+}
+
+type HTTPServer struct {
+	IdleTimeout  time.Duration `envconfig:"HTTP_SERVER_IDLE_TIMEOUT" default:"60s"`
+	Port         int           `envconfig:"PORT" default:"8080"`
+	ReadTimeout  time.Duration `envconfig:"HTTP_SERVER_READ_TIMEOUT" default:"1s"`
+	WriteTimeout time.Duration `envconfig:"HTTP_SERVER_WRITE_TIMEOUT" default:"15s"`
+
+	// ReadinessTimeout bounds the dependency checks of /health/ready, zero
+	// means no timeout
+	ReadinessTimeout time.Duration `envconfig:"HTTP_SERVER_READINESS_TIMEOUT" default:"1s"`
+	// ShutdownDelay keeps the server serving, while /health/ready reports it
+	// is shutting down, before it stops accepting connections
+	ShutdownDelay time.Duration `envconfig:"HTTP_SERVER_SHUTDOWN_DELAY" default:"0s"`
+	// RequestTimeout is the deadline of the store calls of a request to
+	// /api/movies and BatchTimeout of a batch, zero means no timeout. They
+	// should be below WriteTimeout for the client to get the timeout problem,
+	// BatchTimeout is longer as a batch can have up to 1000 operations
+	RequestTimeout time.Duration `envconfig:"HTTP_SERVER_REQUEST_TIMEOUT" default:"1500ms"`
+	BatchTimeout   time.Duration `envconfig:"HTTP_SERVER_BATCH_TIMEOUT" default:"10s"`
+	// RouteTimeouts override RequestTimeout or BatchTimeout for single routes,
+	// e.g. a shorter deadline for search than for writes
+	RouteTimeouts RouteTimeouts `envconfig:"HTTP_SERVER_ROUTE_TIMEOUTS"`
+}
+
+// RouteTimeouts are the timeouts of routes by method and pattern, decoded from
+// comma separated "METHOD pattern=duration" pairs, e.g.
+// "GET /api/movies/search=500ms".
+type RouteTimeouts map[string]time.Duration
+
+// Decode implements envconfig.Decoder, the pattern may contain = itself so
+// each pair is split at its last =.
+func (t *RouteTimeouts) Decode(value string) error {
+	timeouts := RouteTimeouts{}
+	for _, route := range strings.Split(value, ",") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+
+		i := strings.LastIndex(route, "=")
+		if i < 0 {
+			return fmt.Errorf("%q must be METHOD pattern=duration", route)
+		}
+		name := strings.TrimSpace(route[:i])
+		if method, pattern, ok := strings.Cut(name, " "); !ok || method == "" || !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("%q must be METHOD pattern=duration", route)
+		}
+		d, err := time.ParseDuration(route[i+1:])
+		if err != nil {
+			return fmt.Errorf("%q: %w", route, err)
+		}
+		timeouts[name] = d
+	}
+
+	*t = timeouts
+	return nil
+}
+
+// Database defaults suit a single SQLite file, it allows one writer at a time
+// so extra connections would only wait on its lock.
+type Database struct {
+	// Driver selects the store, see store.Drivers for the registered names
+	Driver                string        `envconfig:"STORE_DRIVER" default:"sqlite"`
+	DatabaseURL           string        `envconfig:"DATABASE_URL" default:"file:movies.db"`
+	LogLevel              string        `envconfig:"DATABASE_LOG_LEVEL" default:"warn"`
+	MaxOpenConnections    int           `envconfig:"DATABASE_MAX_OPEN_CONNECTIONS" default:"1"`
+	MaxIdleConnections    int           `envconfig:"DATABASE_MAX_IDLE_CONNECTIONS" default:"1"`
+	ConnectionMaxLifetime time.Duration `envconfig:"DATABASE_CONNECTION_MAX_LIFETIME" default:"0"`
+	ConnectionMaxIdleTime time.Duration `envconfig:"DATABASE_CONNECTION_MAX_IDLE_TIME" default:"0"`
+	AutoMigrate           bool          `envconfig:"DATABASE_AUTO_MIGRATE" default:"true"`
+}
+
+// Tracing selects where spans are exported, none, stdout, file (one JSON span
+// per line in File) or otlp (OTLP over HTTP to OTLPEndpoint).
+type Tracing struct {
+	Exporter     string  `envconfig:"TRACING_EXPORTER" default:"none"`
+	File         string  `envconfig:"TRACING_FILE" default:"traces.json"`
+	OTLPEndpoint string  `envconfig:"TRACING_OTLP_ENDPOINT" default:"localhost:4318"`
+	OTLPInsecure bool    `envconfig:"TRACING_OTLP_INSECURE" default:"true"`
+	ServiceName  string  `envconfig:"TRACING_SERVICE_NAME" default:"movies-api"`
+	SampleRatio  float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1"`
+}
+
+// Logging sets the minimum level of the logs, debug, info, warn or error, and
+// their format, json or text.
+type Logging struct {
+	Level  string `envconfig:"LOG_LEVEL" default:"info"`
+	Format string `envconfig:"LOG_FORMAT" default:"json"`
+}
+
+// Auth configures the authentication of the /api/movies routes. It is off by
+// default so the service runs locally without credentials, once enabled writes
+// always require credentials, reads only if RequireReads is set.
+type Auth struct {
+	Enabled      bool `envconfig:"AUTH_ENABLED" default:"false"`
+	RequireReads bool `envconfig:"AUTH_REQUIRE_READS" default:"false"`
+	// APIKeys and the lines of APIKeysFile are name:hash pairs, hash being the
+	// hex encoded SHA-256 of the key sent in the X-API-Key header
+	APIKeys     []string `envconfig:"AUTH_API_KEYS"`
+	APIKeysFile string   `envconfig:"AUTH_API_KEYS_FILE"`
+	// JWTSecret verifies HS256 bearer tokens, the keys of the JWKS read from
+	// JWKSFile or JWKSURL verify RS256 ones
+	JWTSecret    string        `envconfig:"AUTH_JWT_SECRET"`
+	JWKSFile     string        `envconfig:"AUTH_JWKS_FILE"`
+	JWKSURL      string        `envconfig:"AUTH_JWKS_URL"`
+	JWKSCacheTTL time.Duration `envconfig:"AUTH_JWKS_CACHE_TTL" default:"5m"`
+	JWTIssuer    string        `envconfig:"AUTH_JWT_ISSUER"`
+	JWTAudience  string        `envconfig:"AUTH_JWT_AUDIENCE"`
+	// PolicyFile is the YAML or JSON policy granting scopes to principals,
+	// without one every principal is granted all scopes
+	PolicyFile string `envconfig:"AUTH_POLICY_FILE"`
+}
+
+// RateLimit limits the requests of each client, identified by API key, token
+// subject or IP address, to each /api/movies route with token buckets holding
+// up to Burst requests and refilled at Rate per second. It is off by default
+// like Auth, behind a proxy it needs TrustedProxies or every client shares the
+// bucket of the proxy IP address.
+type RateLimit struct {
+	Enabled bool `envconfig:"RATE_LIMIT_ENABLED" default:"false"`
+	// Backend keeps the buckets, see ratelimit.Backends for the registered names
+	Backend string  `envconfig:"RATE_LIMIT_BACKEND" default:"memory"`
+	Rate    float64 `envconfig:"RATE_LIMIT_RATE" default:"10"`
+	Burst   int     `envconfig:"RATE_LIMIT_BURST" default:"20"`
+	// IPRate and IPBurst limit the requests of each IP address to all routes
+	// before they are authenticated, so failed attempts to guess credentials
+	// are limited too. An IPRate of 0 turns this limit off.
+	IPRate  float64 `envconfig:"RATE_LIMIT_IP_RATE" default:"20"`
+	IPBurst int     `envconfig:"RATE_LIMIT_IP_BURST" default:"40"`
+	// Routes override the limit of routes as "METHOD pattern=rate:burst", e.g.
+	// "GET /api/movies=1:5"
+	Routes []string `envconfig:"RATE_LIMIT_ROUTES"`
+	// TrustedProxies are the CIDRs of proxies whose X-Forwarded-For header
+	// is trusted to carry the client IP
+	TrustedProxies []string `envconfig:"RATE_LIMIT_TRUSTED_PROXIES"`
+}
+
+func Load() (Configuration, error) {
+	var cfg Configuration
+	err := envconfig.Process(envPrefix, &cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
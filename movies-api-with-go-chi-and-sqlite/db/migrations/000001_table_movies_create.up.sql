@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS movies (
+    seq INTEGER PRIMARY KEY,
+    id TEXT NOT NULL UNIQUE,
+    title VARCHAR(100) NOT NULL,
+    director VARCHAR(100) NOT NULL,
+    release_date TIMESTAMP NOT NULL,
+    ticket_price DECIMAL(12, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    version INTEGER DEFAULT 1 NOT NULL
+)
@@ -0,0 +1,19 @@
+CREATE VIRTUAL TABLE IF NOT EXISTS movies_search USING fts5(
+    title,
+    director,
+    content = 'movies',
+    content_rowid = 'seq'
+);
+
+CREATE TRIGGER IF NOT EXISTS movies_search_insert AFTER INSERT ON movies BEGIN
+    INSERT INTO movies_search (rowid, title, director) VALUES (new.seq, new.title, new.director);
+END;
+
+CREATE TRIGGER IF NOT EXISTS movies_search_delete AFTER DELETE ON movies BEGIN
+    INSERT INTO movies_search (movies_search, rowid, title, director) VALUES ('delete', old.seq, old.title, old.director);
+END;
+
+CREATE TRIGGER IF NOT EXISTS movies_search_update AFTER UPDATE OF title, director ON movies BEGIN
+    INSERT INTO movies_search (movies_search, rowid, title, director) VALUES ('delete', old.seq, old.title, old.director);
+    INSERT INTO movies_search (rowid, title, director) VALUES (new.seq, new.title, new.director);
+END;
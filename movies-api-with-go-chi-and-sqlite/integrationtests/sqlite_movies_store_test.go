@@ -0,0 +1,30 @@
+package integrationtests
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/config"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/db"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/store"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/store/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSqliteMoviesStore(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.Database.DatabaseURL = "file:" + filepath.Join(t.TempDir(), "movies.db")
+
+	err = db.Up(context.Background(), cfg.Database)
+	require.NoError(t, err)
+
+	sut, err := store.NewSqliteMoviesStore(context.Background(), cfg.Database)
+	require.NoError(t, err)
+	defer sut.Close()
+
+	storetest.Run(t, func(t *testing.T) store.Interface {
+		return sut
+	})
+}
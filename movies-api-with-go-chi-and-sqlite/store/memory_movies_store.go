@@ -0,0 +1,497 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	titleSearchWeight    = 2
+	directorSearchWeight = 1
+)
+
+type MemoryMoviesStore struct {
+	movies map[uuid.UUID]Movie
+	// index maps each title and director token to the movies containing it
+	// along with the weight of the field it was found in.
+	index map[string]map[uuid.UUID]int
+	// tokens holds the tokens of index in order so that the tokens starting
+	// with a search term are a range of it.
+	tokens []string
+	mu     sync.RWMutex
+
+	// base is the store a transaction started by WithTx reads through, the
+	// transaction keeps only the movies it puts in movies and index and the
+	// IDs it deletes in deleted, WithTx merges them into base on commit
+	base    *MemoryMoviesStore
+	deleted map[uuid.UUID]struct{}
+}
+
+func NewMemoryMoviesStore() *MemoryMoviesStore {
+	return &MemoryMoviesStore{
+		movies: map[uuid.UUID]Movie{},
+		index:  map[string]map[uuid.UUID]int{},
+	}
+}
+
+func newMemoryTx(base *MemoryMoviesStore) *MemoryMoviesStore {
+	tx := NewMemoryMoviesStore()
+	tx.base = base
+	tx.deleted = map[uuid.UUID]struct{}{}
+	return tx
+}
+
+// Ping only fails if ctx is done, the store has no dependencies to reach.
+func (s *MemoryMoviesStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *MemoryMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var movies []Movie
+	s.eachMovie(func(m Movie) {
+		movies = append(movies, m)
+	})
+	sort.Slice(movies, func(i, j int) bool {
+		return compareMovies(movies[i], movies[j], SortByCreatedAt) < 0
+	})
+	return movies, nil
+}
+
+func (s *MemoryMoviesStore) List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch listMoviesParams.SortBy {
+	case "", SortByCreatedAt, SortByTitle, SortByReleaseDate, SortByTicketPrice:
+	default:
+		return MoviesPage{}, &ValidationError{Field: "sort", Message: fmt.Sprintf("unsupported sort field %q", listMoviesParams.SortBy)}
+	}
+
+	var after *Movie
+	if listMoviesParams.After != nil {
+		after = &Movie{
+			ID:          listMoviesParams.After.ID,
+			Title:       listMoviesParams.After.Title,
+			ReleaseDate: listMoviesParams.After.ReleaseDate,
+			TicketPrice: listMoviesParams.After.TicketPrice,
+			CreatedAt:   listMoviesParams.After.CreatedAt,
+		}
+	}
+
+	var movies []Movie
+	s.eachMovie(func(m Movie) {
+		if !matchesListMoviesParams(m, listMoviesParams) {
+			return
+		}
+		if after != nil {
+			c := compareMovies(m, *after, listMoviesParams.SortBy)
+			if listMoviesParams.Descending {
+				c = -c
+			}
+			if c <= 0 {
+				return
+			}
+		}
+		movies = append(movies, m)
+	})
+
+	sort.Slice(movies, func(i, j int) bool {
+		c := compareMovies(movies[i], movies[j], listMoviesParams.SortBy)
+		if listMoviesParams.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	return nextPage(movies, listMoviesParams.Limit), nil
+}
+
+func matchesListMoviesParams(m Movie, listMoviesParams ListMoviesParams) bool {
+	if listMoviesParams.Director != "" && !strings.EqualFold(m.Director, listMoviesParams.Director) {
+		return false
+	}
+	if listMoviesParams.ReleaseDateFrom != nil && m.ReleaseDate.Before(*listMoviesParams.ReleaseDateFrom) {
+		return false
+	}
+	if listMoviesParams.ReleaseDateTo != nil && m.ReleaseDate.After(*listMoviesParams.ReleaseDateTo) {
+		return false
+	}
+	if listMoviesParams.MinTicketPrice != nil && m.TicketPrice < *listMoviesParams.MinTicketPrice {
+		return false
+	}
+	if listMoviesParams.MaxTicketPrice != nil && m.TicketPrice > *listMoviesParams.MaxTicketPrice {
+		return false
+	}
+	return true
+}
+
+// compareMovies orders movies by the sort field, then CreatedAt and ID, the
+// same keyset used by the database stores.
+func compareMovies(a, b Movie, sortBy SortField) int {
+	c := 0
+	switch sortBy {
+	case SortByTitle:
+		// byte order, the SQL stores pin a binary collation to match
+		c = strings.Compare(a.Title, b.Title)
+	case SortByReleaseDate:
+		c = a.ReleaseDate.Compare(b.ReleaseDate)
+	case SortByTicketPrice:
+		if a.TicketPrice < b.TicketPrice {
+			c = -1
+		} else if a.TicketPrice > b.TicketPrice {
+			c = 1
+		}
+	}
+	if c != 0 {
+		return c
+	}
+
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+func (s *MemoryMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.movie(id)
+	if !ok {
+		return Movie{}, &RecordNotFoundError{}
+	}
+
+	return m, nil
+}
+
+func (s *MemoryMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.movie(createMovieParams.ID); ok {
+		return &DuplicateKeyError{ID: createMovieParams.ID}
+	}
+
+	movie := Movie{
+		ID:          createMovieParams.ID,
+		Title:       createMovieParams.Title,
+		Director:    createMovieParams.Director,
+		ReleaseDate: createMovieParams.ReleaseDate,
+		TicketPrice: createMovieParams.TicketPrice,
+		CreatedAt:   time.Now().UTC(),
+		UpdatedAt:   time.Now().UTC(),
+		Version:     1,
+	}
+
+	s.putMovie(movie)
+	return nil
+}
+
+func (s *MemoryMoviesStore) CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := duplicateMovieID(createMoviesParams); ok {
+		return &DuplicateKeyError{ID: id}
+	}
+	for _, p := range createMoviesParams {
+		if _, ok := s.movie(p.ID); ok {
+			return &DuplicateKeyError{ID: p.ID}
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, p := range createMoviesParams {
+		movie := Movie{
+			ID:          p.ID,
+			Title:       p.Title,
+			Director:    p.Director,
+			ReleaseDate: p.ReleaseDate,
+			TicketPrice: p.TicketPrice,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			Version:     1,
+		}
+		s.putMovie(movie)
+	}
+	return nil
+}
+
+func (s *MemoryMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.movie(id)
+	if !ok {
+		return &RecordNotFoundError{}
+	}
+	if updateMovieParams.ExpectedVersion > 0 && m.Version != updateMovieParams.ExpectedVersion {
+		return &VersionMismatchError{ID: id, ExpectedVersion: updateMovieParams.ExpectedVersion}
+	}
+
+	m.Title = updateMovieParams.Title
+	m.Director = updateMovieParams.Director
+	m.ReleaseDate = updateMovieParams.ReleaseDate
+	m.TicketPrice = updateMovieParams.TicketPrice
+	m.UpdatedAt = time.Now().UTC()
+	m.Version++
+
+	s.putMovie(m)
+	return nil
+}
+
+func (s *MemoryMoviesStore) Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.movie(id)
+	if !ok {
+		return &RecordNotFoundError{}
+	}
+	if patchMovieParams.ExpectedVersion > 0 && m.Version != patchMovieParams.ExpectedVersion {
+		return &VersionMismatchError{ID: id, ExpectedVersion: patchMovieParams.ExpectedVersion}
+	}
+
+	if patchMovieParams.Title != nil {
+		m.Title = *patchMovieParams.Title
+	}
+	if patchMovieParams.Director != nil {
+		m.Director = *patchMovieParams.Director
+	}
+	if patchMovieParams.ReleaseDate != nil {
+		m.ReleaseDate = *patchMovieParams.ReleaseDate
+	}
+	if patchMovieParams.TicketPrice != nil {
+		m.TicketPrice = *patchMovieParams.TicketPrice
+	}
+	m.UpdatedAt = time.Now().UTC()
+	m.Version++
+
+	s.putMovie(m)
+	return nil
+}
+
+func (s *MemoryMoviesStore) Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.movie(id)
+	if !ok {
+		return &RecordNotFoundError{}
+	}
+	if deleteMovieParams.ExpectedVersion > 0 && m.Version != deleteMovieParams.ExpectedVersion {
+		return &VersionMismatchError{ID: id, ExpectedVersion: deleteMovieParams.ExpectedVersion}
+	}
+
+	s.deleteMovie(id)
+	return nil
+}
+
+func (s *MemoryMoviesStore) Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error) {
+	return batch(ctx, s, operations, atomic)
+}
+
+// WithTx runs fn against a transaction that reads through to the movies of
+// the store and keeps its own changes, they are merged into the store only if
+// fn succeeds. Other callers wait for fn to return.
+func (s *MemoryMoviesStore) WithTx(ctx context.Context, fn func(tx Interface) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := newMemoryTx(s)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for id := range tx.deleted {
+		s.deleteMovie(id)
+	}
+	for _, m := range tx.movies {
+		s.putMovie(m)
+	}
+	return nil
+}
+
+// Search ranks movies whose title or director contain a token starting with
+// every search term, exact token matches and title matches rank higher.
+func (s *MemoryMoviesStore) Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms, err := parseSearchTerms(searchMoviesParams.Query)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var scores map[uuid.UUID]int
+	for _, term := range terms {
+		termScores := map[uuid.UUID]int{}
+		s.eachPrefixPosting(term, func(token string, id uuid.UUID, weight int) {
+			if token == term {
+				weight *= 2
+			}
+			if weight > termScores[id] {
+				termScores[id] = weight
+			}
+		})
+
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id, score := range scores {
+			if termScore, ok := termScores[id]; ok {
+				scores[id] = score + termScore
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	var movies []Movie
+	for id := range scores {
+		m, _ := s.movie(id)
+		movies = append(movies, m)
+	}
+	sort.Slice(movies, func(i, j int) bool {
+		if scores[movies[i].ID] != scores[movies[j].ID] {
+			return scores[movies[i].ID] > scores[movies[j].ID]
+		}
+		return compareMovies(movies[i], movies[j], SortByTitle) < 0
+	})
+
+	if searchMoviesParams.Limit > 0 && len(movies) > searchMoviesParams.Limit {
+		movies = movies[:searchMoviesParams.Limit]
+	}
+	return movies, nil
+}
+
+// movie returns the movie with id, a transaction looks it up in its base
+// unless it put or deleted it.
+func (s *MemoryMoviesStore) movie(id uuid.UUID) (Movie, bool) {
+	if m, ok := s.movies[id]; ok {
+		return m, true
+	}
+	if s.base == nil {
+		return Movie{}, false
+	}
+	if _, ok := s.deleted[id]; ok {
+		return Movie{}, false
+	}
+	return s.base.movie(id)
+}
+
+// eachMovie calls fn with every movie, including the movies of the base of a
+// transaction it did not put or delete.
+func (s *MemoryMoviesStore) eachMovie(fn func(m Movie)) {
+	for _, m := range s.movies {
+		fn(m)
+	}
+	if s.base == nil {
+		return
+	}
+	s.base.eachMovie(func(m Movie) {
+		if !s.changed(m.ID) {
+			fn(m)
+		}
+	})
+}
+
+// eachPrefixPosting calls fn with every token of the index starting with
+// prefix and the movies it is found in, hiding the postings of the base of a
+// transaction for the movies it put or deleted.
+func (s *MemoryMoviesStore) eachPrefixPosting(prefix string, fn func(token string, id uuid.UUID, weight int)) {
+	for i := sort.SearchStrings(s.tokens, prefix); i < len(s.tokens) && strings.HasPrefix(s.tokens[i], prefix); i++ {
+		for id, weight := range s.index[s.tokens[i]] {
+			fn(s.tokens[i], id, weight)
+		}
+	}
+	if s.base == nil {
+		return
+	}
+	s.base.eachPrefixPosting(prefix, func(token string, id uuid.UUID, weight int) {
+		if !s.changed(id) {
+			fn(token, id, weight)
+		}
+	})
+}
+
+// changed reports whether a transaction put or deleted the movie with id.
+func (s *MemoryMoviesStore) changed(id uuid.UUID) bool {
+	if _, ok := s.movies[id]; ok {
+		return true
+	}
+	_, ok := s.deleted[id]
+	return ok
+}
+
+func (s *MemoryMoviesStore) putMovie(m Movie) {
+	if previous, ok := s.movies[m.ID]; ok {
+		s.unindexMovie(previous)
+	}
+	s.movies[m.ID] = m
+	s.indexMovie(m)
+	delete(s.deleted, m.ID)
+}
+
+func (s *MemoryMoviesStore) deleteMovie(id uuid.UUID) {
+	if m, ok := s.movies[id]; ok {
+		s.unindexMovie(m)
+		delete(s.movies, id)
+	}
+	if s.base == nil {
+		return
+	}
+	if _, ok := s.base.movie(id); ok {
+		s.deleted[id] = struct{}{}
+	}
+}
+
+func (s *MemoryMoviesStore) indexMovie(m Movie) {
+	fields := []struct {
+		value  string
+		weight int
+	}{
+		{value: m.Title, weight: titleSearchWeight},
+		{value: m.Director, weight: directorSearchWeight},
+	}
+	for _, field := range fields {
+		for _, token := range searchTerms(field.value) {
+			postings, ok := s.index[token]
+			if !ok {
+				postings = map[uuid.UUID]int{}
+				s.index[token] = postings
+				i := sort.SearchStrings(s.tokens, token)
+				s.tokens = append(s.tokens, "")
+				copy(s.tokens[i+1:], s.tokens[i:])
+				s.tokens[i] = token
+			}
+			if field.weight > postings[m.ID] {
+				postings[m.ID] = field.weight
+			}
+		}
+	}
+}
+
+func (s *MemoryMoviesStore) unindexMovie(m Movie) {
+	for _, token := range searchTerms(m.Title + " " + m.Director) {
+		delete(s.index[token], m.ID)
+		if postings, ok := s.index[token]; ok && len(postings) == 0 {
+			delete(s.index, token)
+			i := sort.SearchStrings(s.tokens, token)
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// buildPatchMovieQuery returns the SET and WHERE clauses for Patch along with
+// the named arguments to bind, only the fields present in patchMovieParams are
+// set while UpdatedAt and Version always change.
+func buildPatchMovieQuery(columns movieColumns, id uuid.UUID, patchMovieParams PatchMovieParams, updatedAt time.Time) (string, string, map[string]any) {
+	assignments := []string{}
+	args := map[string]any{}
+
+	set := func(column string, param string, value any) {
+		assignments = append(assignments, fmt.Sprintf("%s = :%s", column, param))
+		args[param] = value
+	}
+	if patchMovieParams.Title != nil {
+		set(columns.Title, "title", *patchMovieParams.Title)
+	}
+	if patchMovieParams.Director != nil {
+		set(columns.Director, "director", *patchMovieParams.Director)
+	}
+	if patchMovieParams.ReleaseDate != nil {
+		set(columns.ReleaseDate, "release_date", *patchMovieParams.ReleaseDate)
+	}
+	if patchMovieParams.TicketPrice != nil {
+		set(columns.TicketPrice, "ticket_price", *patchMovieParams.TicketPrice)
+	}
+	set(columns.UpdatedAt, "updated_at", updatedAt)
+	assignments = append(assignments, fmt.Sprintf("%[1]s = %[1]s + 1", columns.Version))
+
+	where := fmt.Sprintf("WHERE %s = :id", columns.ID)
+	args["id"] = id
+	if patchMovieParams.ExpectedVersion > 0 {
+		where += fmt.Sprintf(" AND %s = :version", columns.Version)
+		args["version"] = patchMovieParams.ExpectedVersion
+	}
+
+	return "SET " + strings.Join(assignments, ", "), where, args
+}
@@ -0,0 +1,414 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlite/config"
+	_ "modernc.org/sqlite"
+)
+
+const driverName = "sqlite"
+
+// SqliteDriver is the STORE_DRIVER name of SqliteMoviesStore.
+const SqliteDriver = "sqlite"
+
+func init() {
+	Register(SqliteDriver, Driver{
+		Validate: validateSqliteConfig,
+		Open: func(ctx context.Context, config config.Database) (Closer, error) {
+			s, err := NewSqliteMoviesStore(ctx, config)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	})
+}
+
+func validateSqliteConfig(config config.Database) error {
+	return requireDatabaseURL(config)
+}
+
+type SqliteMoviesStore struct {
+	db *sqlx.DB
+	// dbx is db, or the transaction the store is bound to by inTx
+	dbx sqlxExecutor
+}
+
+// NewSqliteMoviesStore opens the SQLite database named by config.DatabaseURL,
+// e.g. file:movies.db. Times are written in the sqlite format unless the URL
+// sets _time_format, the store compares them as text and keeps them in UTC.
+// Unless the URL sets them, a busy timeout makes concurrent writers wait for
+// each other instead of failing with SQLITE_BUSY and the WAL journal lets
+// readers proceed during a write.
+func NewSqliteMoviesStore(ctx context.Context, config config.Database) (*SqliteMoviesStore, error) {
+	dbx, err := sqlx.ConnectContext(ctx, driverName, withDefaults(config.DatabaseURL))
+	if err != nil {
+		return nil, err
+	}
+
+	dbx.SetMaxOpenConns(config.MaxOpenConnections)
+	dbx.SetMaxIdleConns(config.MaxIdleConnections)
+	dbx.SetConnMaxLifetime(config.ConnectionMaxLifetime)
+	dbx.SetConnMaxIdleTime(config.ConnectionMaxIdleTime)
+
+	return &SqliteMoviesStore{
+		db:  dbx,
+		dbx: tracedExecutor{dbx},
+	}, nil
+}
+
+// withDefaults adds the query parameters of the store to databaseURL that it
+// does not set itself.
+func withDefaults(databaseURL string) string {
+	for _, param := range []struct {
+		name  string
+		value string
+	}{
+		{name: "_time_format=", value: "_time_format=sqlite"},
+		{name: "busy_timeout", value: "_pragma=busy_timeout(5000)"},
+		{name: "journal_mode", value: "_pragma=journal_mode(WAL)"},
+	} {
+		if strings.Contains(databaseURL, param.name) {
+			continue
+		}
+		if strings.Contains(databaseURL, "?") {
+			databaseURL += "&" + param.value
+		} else {
+			databaseURL += "?" + param.value
+		}
+	}
+	return databaseURL
+}
+
+func (s *SqliteMoviesStore) Close() error {
+	return s.db.Close()
+}
+
+// DB returns the connection pool of the store, e.g. to collect its stats.
+func (s *SqliteMoviesStore) DB() *sql.DB {
+	return s.db.DB
+}
+
+func (s *SqliteMoviesStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SqliteMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
+	var movies []Movie
+	if err := s.dbx.SelectContext(
+		ctx,
+		&movies,
+		`SELECT
+			id, title, director, release_date, ticket_price, created_at, updated_at, version
+		FROM movies`); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+var sqliteMovieColumns = movieColumns{
+	ID:          "id",
+	SortID:      "id",
+	Title:       "title",
+	SortTitle:   "title",
+	Director:    "director",
+	ReleaseDate: "release_date",
+	TicketPrice: "ticket_price",
+	CreatedAt:   "created_at",
+	UpdatedAt:   "updated_at",
+	Version:     "version",
+}
+
+func (s *SqliteMoviesStore) List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error) {
+	where, orderBy, args, err := buildListMoviesQuery(sqliteMovieColumns, listMoviesParams)
+	if err != nil {
+		return MoviesPage{}, err
+	}
+
+	limit := ""
+	if listMoviesParams.Limit > 0 {
+		limit = "LIMIT :limit"
+		args["limit"] = listMoviesParams.Limit + 1
+	}
+
+	query, queryArgs, err := s.dbx.BindNamed(
+		`SELECT
+			id, title, director, release_date, ticket_price, created_at, updated_at, version
+		FROM movies
+		`+where+`
+		`+orderBy+`
+		`+limit,
+		utcTimes(args))
+	if err != nil {
+		return MoviesPage{}, err
+	}
+
+	var movies []Movie
+	if err := s.dbx.SelectContext(ctx, &movies, query, queryArgs...); err != nil {
+		return MoviesPage{}, err
+	}
+
+	return nextPage(movies, listMoviesParams.Limit), nil
+}
+
+// Search matches the search terms as prefixes against the full text index of
+// title and director, ranking results with bm25 and title matches first.
+func (s *SqliteMoviesStore) Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error) {
+	terms, err := parseSearchTerms(searchMoviesParams.Query)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	for i, term := range terms {
+		terms[i] = `"` + term + `"*`
+	}
+
+	args := []any{strings.Join(terms, " ")}
+	limit := ""
+	if searchMoviesParams.Limit > 0 {
+		limit = "LIMIT ?"
+		args = append(args, searchMoviesParams.Limit)
+	}
+
+	var movies []Movie
+	if err := s.dbx.SelectContext(
+		ctx,
+		&movies,
+		`SELECT
+			m.id, m.title, m.director, m.release_date, m.ticket_price, m.created_at, m.updated_at, m.version
+		FROM movies_search
+		JOIN movies m ON m.seq = movies_search.rowid
+		WHERE movies_search MATCH ?
+		ORDER BY bm25(movies_search, 2.0, 1.0), m.title, m.created_at, m.id
+		`+limit,
+		args...); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+func (s *SqliteMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
+	var movie Movie
+	if err := s.dbx.GetContext(
+		ctx,
+		&movie,
+		`SELECT
+			id, title, director, release_date, ticket_price, created_at, updated_at, version
+		FROM movies
+		WHERE id = ?`,
+		id); err != nil {
+		if err != sql.ErrNoRows {
+			return Movie{}, err
+		}
+
+		return Movie{}, &RecordNotFoundError{}
+	}
+
+	return movie, nil
+}
+
+const insertMovieQuery = `INSERT INTO movies
+		(id, title, director, release_date, ticket_price, created_at, updated_at, version)
+	VALUES
+		(:id, :title, :director, :release_date, :ticket_price, :created_at, :updated_at, :version)`
+
+func (s *SqliteMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
+	movie := Movie{
+		ID:          createMovieParams.ID,
+		Title:       createMovieParams.Title,
+		Director:    createMovieParams.Director,
+		ReleaseDate: createMovieParams.ReleaseDate.UTC(),
+		TicketPrice: createMovieParams.TicketPrice,
+		CreatedAt:   time.Now().UTC(),
+		UpdatedAt:   time.Now().UTC(),
+		Version:     1,
+	}
+
+	if _, err := s.dbx.NamedExecContext(ctx, insertMovieQuery, movie); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: movies.id") {
+			return &DuplicateKeyError{ID: createMovieParams.ID}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// CreateMany inserts the movies one at a time in a transaction, without a
+// network round trip per statement this is as fast as a multi-row insert and
+// sqlite does not report which row of one clashed.
+func (s *SqliteMoviesStore) CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error {
+	if id, ok := duplicateMovieID(createMoviesParams); ok {
+		return &DuplicateKeyError{ID: id}
+	}
+
+	return s.inTx(ctx, func(tx *SqliteMoviesStore) error {
+		for _, p := range createMoviesParams {
+			if err := tx.Create(ctx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SqliteMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
+	movie := Movie{
+		ID:          id,
+		Title:       updateMovieParams.Title,
+		Director:    updateMovieParams.Director,
+		ReleaseDate: updateMovieParams.ReleaseDate.UTC(),
+		TicketPrice: updateMovieParams.TicketPrice,
+		UpdatedAt:   time.Now().UTC(),
+		Version:     updateMovieParams.ExpectedVersion,
+	}
+
+	query := `UPDATE movies
+		SET title = :title, director = :director, release_date = :release_date, ticket_price = :ticket_price, updated_at = :updated_at, version = version + 1
+		WHERE id = :id`
+	if updateMovieParams.ExpectedVersion > 0 {
+		query += ` AND version = :version`
+	}
+
+	result, err := s.dbx.NamedExecContext(ctx, query, movie)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, updateMovieParams.ExpectedVersion)
+	}
+
+	return nil
+}
+
+func (s *SqliteMoviesStore) Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error {
+	set, where, args := buildPatchMovieQuery(sqliteMovieColumns, id, patchMovieParams, time.Now().UTC())
+	query := `UPDATE movies
+		` + set + `
+		` + where
+
+	result, err := s.dbx.NamedExecContext(ctx, query, utcTimes(args))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, patchMovieParams.ExpectedVersion)
+	}
+
+	return nil
+}
+
+func (s *SqliteMoviesStore) Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error {
+	query := `DELETE FROM movies
+		WHERE id = ?`
+	args := []any{id}
+	if deleteMovieParams.ExpectedVersion > 0 {
+		query += ` AND version = ?`
+		args = append(args, deleteMovieParams.ExpectedVersion)
+	}
+
+	result, err := s.dbx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, deleteMovieParams.ExpectedVersion)
+	}
+
+	return nil
+}
+
+func (s *SqliteMoviesStore) Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error) {
+	return batch(ctx, s, operations, atomic)
+}
+
+func (s *SqliteMoviesStore) WithTx(ctx context.Context, fn func(tx Interface) error) error {
+	return s.inTx(ctx, func(tx *SqliteMoviesStore) error {
+		return fn(tx)
+	})
+}
+
+// inTx runs fn with a copy of the store bound to a transaction, which is
+// committed if fn succeeds. A store already bound to one reuses it.
+func (s *SqliteMoviesStore) inTx(ctx context.Context, fn func(tx *SqliteMoviesStore) error) error {
+	if boundToTx(s.dbx) {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&SqliteMoviesStore{db: s.db, dbx: tracedExecutor{tx}}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// noRowsAffectedError tells apart a missing movie from a version mismatch
+// when a conditional write did not affect any rows.
+func (s *SqliteMoviesStore) noRowsAffectedError(ctx context.Context, id uuid.UUID, expectedVersion int64) error {
+	if expectedVersion == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	var count int
+	if err := s.dbx.GetContext(
+		ctx,
+		&count,
+		`SELECT COUNT(*) FROM movies
+		WHERE id = ?`,
+		id); err != nil {
+		return err
+	}
+	if count == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	return &VersionMismatchError{ID: id, ExpectedVersion: expectedVersion}
+}
+
+// utcTimes converts the times in args to UTC, sqlite compares them as text so
+// every stored and compared time has to be in the same zone.
+func utcTimes(args map[string]any) map[string]any {
+	for name, value := range args {
+		if t, ok := value.(time.Time); ok {
+			args[name] = t.UTC()
+		}
+	}
+	return args
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// sqlxExecutor is implemented by both *sqlx.DB and *sqlx.Tx, the SQL stores
+// run their statements through it so the same code works in a transaction.
+type sqlxExecutor interface {
+	sqlx.ExtContext
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// tracedExecutor records the statements run through it as the db.statement of
+// the store span in their context.
+type tracedExecutor struct {
+	sqlxExecutor
+}
+
+func (e tracedExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	recordStatement(ctx, query)
+	return e.sqlxExecutor.QueryContext(ctx, query, args...)
+}
+
+func (e tracedExecutor) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	recordStatement(ctx, query)
+	return e.sqlxExecutor.QueryxContext(ctx, query, args...)
+}
+
+func (e tracedExecutor) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	recordStatement(ctx, query)
+	return e.sqlxExecutor.QueryRowxContext(ctx, query, args...)
+}
+
+func (e tracedExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	recordStatement(ctx, query)
+	return e.sqlxExecutor.ExecContext(ctx, query, args...)
+}
+
+func (e tracedExecutor) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	recordStatement(ctx, query)
+	return e.sqlxExecutor.NamedExecContext(ctx, query, arg)
+}
+
+func (e tracedExecutor) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	recordStatement(ctx, query)
+	return e.sqlxExecutor.GetContext(ctx, dest, query, args...)
+}
+
+func (e tracedExecutor) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	recordStatement(ctx, query)
+	return e.sqlxExecutor.SelectContext(ctx, dest, query, args...)
+}
+
+// boundToTx reports whether dbx runs its statements in a transaction.
+func boundToTx(dbx sqlxExecutor) bool {
+	if traced, ok := dbx.(tracedExecutor); ok {
+		dbx = traced.sqlxExecutor
+	}
+	_, ok := dbx.(*sqlx.Tx)
+	return ok
+}
+
+// reportedMovieID returns the id of the movie in createMoviesParams named by a
+// database error message, databases report the offending key of a multi-row
+// insert rather than its position.
+func reportedMovieID(message string, createMoviesParams []CreateMovieParams) (uuid.UUID, bool) {
+	message = strings.ToLower(message)
+	for _, p := range createMoviesParams {
+		if strings.Contains(message, p.ID.String()) {
+			return p.ID, true
+		}
+	}
+	return uuid.Nil, false
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLength    = 100
+	maxDirectorLength = 100
+	// TicketPrice is DECIMAL(12, 4)
+	ticketPricePrecision = 12
+	ticketPriceScale     = 4
+	// how far in the future an upcoming release can be scheduled
+	maxReleaseDateAhead = 10 * 365 * 24 * time.Hour
+)
+
+// first public film screening
+var minReleaseDate = time.Date(1888, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := []string{}
+	for _, fe := range e.Errors {
+		messages = append(messages, fe.Field+": "+fe.Message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) check(ok bool, field string, message string) {
+	if !ok {
+		v.errors = append(v.errors, FieldError{Field: field, Message: message})
+	}
+}
+
+// decode unmarshals a JSON value into target, recording message against field
+// if the value has the wrong type.
+func (v *validator) decode(value json.RawMessage, target any, field string, message string) bool {
+	err := json.Unmarshal(value, target)
+	v.check(err == nil, field, message)
+	return err == nil
+}
+
+// merge records the field errors of a nested validation error, prefixing
+// their field names with prefix.
+func (v *validator) merge(prefix string, err error) {
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		return
+	}
+	for _, fe := range validationErr.Errors {
+		v.errors = append(v.errors, FieldError{Field: prefix + fe.Field, Message: fe.Message})
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errors}
+}
+
+func (v *validator) checkText(value string, field string, maxLength int) {
+	v.check(strings.TrimSpace(value) != "", field, "must not be empty")
+	v.check(utf8.RuneCountInString(value) <= maxLength, field, fmt.Sprintf("must be at most %d characters", maxLength))
+}
+
+func (v *validator) checkReleaseDate(value time.Time, field string) {
+	v.check(!value.IsZero(), field, "is required")
+	if value.IsZero() {
+		return
+	}
+	v.check(!value.Before(minReleaseDate), field, "must not be before "+minReleaseDate.Format("2006-01-02"))
+	v.check(!value.After(time.Now().Add(maxReleaseDateAhead)), field, "is too far in the future")
+}
+
+func (v *validator) checkTicketPrice(value float64, field string) {
+	v.check(value >= 0, field, "must not be negative")
+	v.check(value < math.Pow10(ticketPricePrecision-ticketPriceScale), field, "is too large")
+
+	// Count the decimal places of the shortest decimal that parses back to
+	// value, which is the JSON number as sent minus any trailing zeros.
+	// Scaling by 10^scale and comparing against a fixed tolerance fails for
+	// large prices, where float64 cannot resolve the tolerance.
+	decimals := 0
+	if s := strconv.FormatFloat(value, 'f', -1, 64); strings.Contains(s, ".") {
+		decimals = len(s) - strings.Index(s, ".") - 1
+	}
+	v.check(decimals <= ticketPriceScale, field, fmt.Sprintf("must have at most %d decimal places", ticketPriceScale))
+}
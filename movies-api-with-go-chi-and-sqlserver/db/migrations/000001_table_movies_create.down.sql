@@ -1,4 +1,4 @@
-IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='Movies' and xtype='U')
+IF EXISTS (SELECT * FROM sysobjects WHERE name='Movies' and xtype='U')
 BEGIN
     DROP TABLE Movies
 END
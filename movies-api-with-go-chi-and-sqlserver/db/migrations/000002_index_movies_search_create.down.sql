@@ -0,0 +1,9 @@
+IF EXISTS (SELECT * FROM sys.fulltext_indexes WHERE object_id = OBJECT_ID('Movies'))
+BEGIN
+    EXEC('DROP FULLTEXT INDEX ON Movies');
+END
+IF EXISTS (SELECT * FROM sys.fulltext_catalogs WHERE name = 'MoviesCatalog')
+BEGIN
+    EXEC('DROP FULLTEXT CATALOG MoviesCatalog');
+END
+DROP INDEX IF EXISTS UX_Movies_Id ON Movies;
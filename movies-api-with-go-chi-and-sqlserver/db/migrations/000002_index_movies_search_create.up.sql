@@ -0,0 +1,6 @@
+IF CAST(SERVERPROPERTY('IsFullTextInstalled') AS INT) = 1
+BEGIN
+    CREATE UNIQUE INDEX UX_Movies_Id ON Movies (Id);
+    EXEC('CREATE FULLTEXT CATALOG MoviesCatalog');
+    EXEC('CREATE FULLTEXT INDEX ON Movies (Title, Director) KEY INDEX UX_Movies_Id ON MoviesCatalog');
+END
@@ -0,0 +1,5 @@
+IF COL_LENGTH('Movies', 'Version') IS NOT NULL
+BEGIN
+    ALTER TABLE Movies DROP CONSTRAINT DF_Movies_Version;
+    ALTER TABLE Movies DROP COLUMN Version;
+END
@@ -0,0 +1,4 @@
+IF COL_LENGTH('Movies', 'Version') IS NULL
+BEGIN
+    ALTER TABLE Movies ADD Version BIGINT NOT NULL CONSTRAINT DF_Movies_Version DEFAULT 1;
+END
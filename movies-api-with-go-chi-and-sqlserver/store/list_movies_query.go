@@ -0,0 +1,120 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+type movieColumns struct {
+	ID string
+	// SortID orders and compares ids by their bytes like the memory store, so
+	// that a cursor pages the same way whatever the type of the ID column
+	SortID string
+	Title  string
+	// SortTitle orders and compares titles byte by byte like the memory and
+	// MongoDB stores, whatever the collation of the Title column
+	SortTitle   string
+	Director    string
+	ReleaseDate string
+	TicketPrice string
+	CreatedAt   string
+	UpdatedAt   string
+	Version     string
+}
+
+type keysetColumn struct {
+	column string
+	param  string
+	value  any
+}
+
+// buildListMoviesQuery returns the WHERE and ORDER BY clauses for List along
+// with the named arguments to bind, columns names the columns and sort
+// expressions of the database.
+func buildListMoviesQuery(columns movieColumns, listMoviesParams ListMoviesParams) (string, string, map[string]any, error) {
+	keyset, err := listMoviesKeyset(columns, listMoviesParams)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	conditions := []string{}
+	args := map[string]any{}
+
+	if listMoviesParams.Director != "" {
+		conditions = append(conditions, fmt.Sprintf("LOWER(%s) = LOWER(:director)", columns.Director))
+		args["director"] = listMoviesParams.Director
+	}
+	if listMoviesParams.ReleaseDateFrom != nil {
+		conditions = append(conditions, fmt.Sprintf("%s >= :release_date_from", columns.ReleaseDate))
+		args["release_date_from"] = *listMoviesParams.ReleaseDateFrom
+	}
+	if listMoviesParams.ReleaseDateTo != nil {
+		conditions = append(conditions, fmt.Sprintf("%s <= :release_date_to", columns.ReleaseDate))
+		args["release_date_to"] = *listMoviesParams.ReleaseDateTo
+	}
+	if listMoviesParams.MinTicketPrice != nil {
+		conditions = append(conditions, fmt.Sprintf("%s >= :min_ticket_price", columns.TicketPrice))
+		args["min_ticket_price"] = *listMoviesParams.MinTicketPrice
+	}
+	if listMoviesParams.MaxTicketPrice != nil {
+		conditions = append(conditions, fmt.Sprintf("%s <= :max_ticket_price", columns.TicketPrice))
+		args["max_ticket_price"] = *listMoviesParams.MaxTicketPrice
+	}
+
+	operator, direction := ">", "ASC"
+	if listMoviesParams.Descending {
+		operator, direction = "<", "DESC"
+	}
+
+	if listMoviesParams.After != nil {
+		// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR (k1 = v1 AND k2 = v2 AND k3 > v3)
+		alternatives := []string{}
+		for i, key := range keyset {
+			terms := []string{}
+			for _, previous := range keyset[:i] {
+				terms = append(terms, fmt.Sprintf("%s = :%s", previous.column, previous.param))
+			}
+			terms = append(terms, fmt.Sprintf("%s %s :%s", key.column, operator, key.param))
+			alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+			args[key.param] = key.value
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	orderBy := []string{}
+	for _, key := range keyset {
+		orderBy = append(orderBy, key.column+" "+direction)
+	}
+
+	return where, "ORDER BY " + strings.Join(orderBy, ", "), args, nil
+}
+
+func listMoviesKeyset(columns movieColumns, listMoviesParams ListMoviesParams) ([]keysetColumn, error) {
+	after := listMoviesParams.After
+	if after == nil {
+		after = &MovieCursor{}
+	}
+
+	keyset := []keysetColumn{
+		{column: columns.CreatedAt, param: "after_created_at", value: after.CreatedAt},
+		{column: columns.SortID, param: "after_id", value: after.ID},
+	}
+
+	switch listMoviesParams.SortBy {
+	case "", SortByCreatedAt:
+		return keyset, nil
+	case SortByTitle:
+		return append([]keysetColumn{{column: columns.SortTitle, param: "after_title", value: after.Title}}, keyset...), nil
+	case SortByReleaseDate:
+		return append([]keysetColumn{{column: columns.ReleaseDate, param: "after_release_date", value: after.ReleaseDate}}, keyset...), nil
+	case SortByTicketPrice:
+		return append([]keysetColumn{{column: columns.TicketPrice, param: "after_ticket_price", value: after.TicketPrice}}, keyset...), nil
+	default:
+		return nil, &ValidationError{Field: "sort", Message: fmt.Sprintf("unsupported sort field %q", listMoviesParams.SortBy)}
+	}
+}
@@ -0,0 +1,25 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildListMoviesQuery(t *testing.T) {
+	t.Run("given title sort, should order titles and ids by their bytes", func(t *testing.T) {
+		after := &MovieCursor{Title: "Bravo", CreatedAt: time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC), ID: uuid.New()}
+
+		where, orderBy, args, err := buildListMoviesQuery(sqlServerMovieColumns, ListMoviesParams{SortBy: SortByTitle, After: after})
+
+		require.NoError(t, err)
+		title := "CAST(Title AS NVARCHAR(100)) COLLATE Latin1_General_BIN2"
+		id := "LOWER(CONVERT(CHAR(36), Id)) COLLATE Latin1_General_BIN2"
+		assert.Equal(t, "WHERE (("+title+" > :after_title) OR ("+title+" = :after_title AND CreatedAt > :after_created_at) OR ("+title+" = :after_title AND CreatedAt = :after_created_at AND "+id+" > :after_id))", where)
+		assert.Equal(t, "ORDER BY "+title+" ASC, CreatedAt ASC, "+id+" ASC", orderBy)
+		assert.Equal(t, after.ID, args["after_id"])
+	})
+}
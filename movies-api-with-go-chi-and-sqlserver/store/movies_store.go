@@ -2,7 +2,10 @@ package store
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 )
@@ -15,6 +18,7 @@ type Movie struct {
 	TicketPrice float64
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	Version     int64
 }
 
 type CreateMovieParams struct {
@@ -30,12 +34,137 @@ type UpdateMovieParams struct {
 	Director    string
 	ReleaseDate time.Time
 	TicketPrice float64
+	// ExpectedVersion makes the update conditional on the stored version,
+	// zero updates regardless of the version.
+	ExpectedVersion int64
+}
+
+// PatchMovieParams holds the fields to change, nil fields are left as they are.
+type PatchMovieParams struct {
+	Title       *string
+	Director    *string
+	ReleaseDate *time.Time
+	TicketPrice *float64
+	// ExpectedVersion makes the patch conditional on the stored version,
+	// zero patches regardless of the version.
+	ExpectedVersion int64
+}
+
+type DeleteMovieParams struct {
+	// ExpectedVersion makes the delete conditional on the stored version,
+	// zero deletes regardless of the version.
+	ExpectedVersion int64
+}
+
+type SortField string
+
+const (
+	SortByCreatedAt   SortField = "created_at"
+	SortByTitle       SortField = "title"
+	SortByReleaseDate SortField = "release_date"
+	SortByTicketPrice SortField = "ticket_price"
+)
+
+// MovieCursor holds the keyset of the last movie on a page, List resumes
+// after it using the sort field followed by CreatedAt and ID as tie breakers.
+type MovieCursor struct {
+	Title       string    `json:"title,omitempty"`
+	ReleaseDate time.Time `json:"release_date"`
+	TicketPrice float64   `json:"ticket_price,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ID          uuid.UUID `json:"id"`
+}
+
+func NewMovieCursor(m Movie) *MovieCursor {
+	return &MovieCursor{
+		Title:       m.Title,
+		ReleaseDate: m.ReleaseDate,
+		TicketPrice: m.TicketPrice,
+		CreatedAt:   m.CreatedAt,
+		ID:          m.ID,
+	}
+}
+
+type ListMoviesParams struct {
+	Limit      int
+	After      *MovieCursor
+	SortBy     SortField
+	Descending bool
+
+	Director        string
+	ReleaseDateFrom *time.Time
+	ReleaseDateTo   *time.Time
+	MinTicketPrice  *float64
+	MaxTicketPrice  *float64
+}
+
+type MoviesPage struct {
+	Movies []Movie
+	Next   *MovieCursor
+}
+
+type SearchMoviesParams struct {
+	Query string
+	Limit int
 }
 
 type Interface interface {
 	GetAll(ctx context.Context) ([]Movie, error)
+	List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error)
+	Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error)
 	GetByID(ctx context.Context, id uuid.UUID) (Movie, error)
 	Create(ctx context.Context, createMovieParams CreateMovieParams) error
+	CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error
 	Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error
-	Delete(ctx context.Context, id uuid.UUID) error
+	Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error
+	Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error
+	Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error)
+	// WithTx runs fn as a unit of work, its changes through tx are committed
+	// together if fn returns nil and rolled back otherwise. tx must only be
+	// used within fn.
+	WithTx(ctx context.Context, fn func(tx Interface) error) error
+	// Ping checks the store can serve requests, e.g. that its database is
+	// reachable, within the deadline of ctx.
+	Ping(ctx context.Context) error
+}
+
+// nextPage trims the extra movie fetched to detect whether another page exists
+// and returns the cursor to resume from.
+func nextPage(movies []Movie, limit int) MoviesPage {
+	if limit <= 0 || len(movies) <= limit {
+		return MoviesPage{Movies: movies}
+	}
+
+	movies = movies[:limit]
+	return MoviesPage{
+		Movies: movies,
+		Next:   NewMovieCursor(movies[limit-1]),
+	}
+}
+
+// MaxSearchTerms is the most terms Search accepts in a query, the SQL Server
+// store binds several parameters per term and a query is limited to 2100.
+const MaxSearchTerms = 10
+
+// SearchTermCount returns the number of terms Search splits query into.
+func SearchTermCount(query string) int {
+	return len(searchTerms(query))
+}
+
+// parseSearchTerms returns the terms of query, failing with a ValidationError
+// when there are more than MaxSearchTerms of them.
+func parseSearchTerms(query string) ([]string, error) {
+	terms := searchTerms(query)
+	if len(terms) > MaxSearchTerms {
+		return nil, &ValidationError{Field: "q", Message: fmt.Sprintf("must have at most %d words", MaxSearchTerms)}
+	}
+	return terms, nil
+}
+
+// searchTerms splits a search query into lower case words, dropping any
+// punctuation so the terms are safe to use in a native search expression.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
 }
@@ -3,57 +3,104 @@ package store
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/kashifsoofi/blog-code-samples/movies-api-with-go-chi-and-sqlserver/config"
 	_ "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
 )
 
 const driverName = "sqlserver"
 
-type SqlServerMoviesStore struct {
-	databaseUrl string
-	dbx         *sqlx.DB
+// SqlServerDriver is the STORE_DRIVER name of SqlServerMoviesStore.
+const SqlServerDriver = "sqlserver"
+
+func init() {
+	Register(SqlServerDriver, Driver{
+		Validate: validateSqlServerConfig,
+		Open: func(ctx context.Context, config config.Database) (Closer, error) {
+			s, err := NewSqlServerMoviesStore(ctx, config)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	})
 }
 
-func NewSqlServerMoviesStore(databaseUrl string) *SqlServerMoviesStore {
-	return &SqlServerMoviesStore{
-		databaseUrl: databaseUrl,
+func validateSqlServerConfig(config config.Database) error {
+	if err := requireDatabaseURL(config); err != nil {
+		return err
+	}
+	if _, err := msdsn.Parse(config.DatabaseURL); err != nil {
+		return fmt.Errorf("invalid DATABASE_URL: %w", err)
 	}
+	return nil
+}
+
+type SqlServerMoviesStore struct {
+	db *sqlx.DB
+	// dbx is db, or the transaction the store is bound to by inTx
+	dbx sqlxExecutor
+	// fullText is set when the Movies table has a full-text index, it is
+	// only created by the migrations if Full-Text Search is installed.
+	fullText bool
 }
 
 func noOpMapper(s string) string { return s }
 
-func (s *SqlServerMoviesStore) connect(ctx context.Context) error {
-	dbx, err := sqlx.ConnectContext(ctx, driverName, s.databaseUrl)
+func NewSqlServerMoviesStore(ctx context.Context, config config.Database) (*SqlServerMoviesStore, error) {
+	dbx, err := sqlx.ConnectContext(ctx, driverName, config.DatabaseURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	dbx.MapperFunc(noOpMapper)
-	s.dbx = dbx
-	return nil
+	dbx.SetMaxOpenConns(config.MaxOpenConnections)
+	dbx.SetMaxIdleConns(config.MaxIdleConnections)
+	dbx.SetConnMaxLifetime(config.ConnectionMaxLifetime)
+	dbx.SetConnMaxIdleTime(config.ConnectionMaxIdleTime)
+
+	var fullText bool
+	if err := dbx.GetContext(
+		ctx,
+		&fullText,
+		`SELECT CAST(COALESCE(OBJECTPROPERTY(OBJECT_ID('Movies'), 'TableHasActiveFulltextIndex'), 0) AS BIT)`); err != nil {
+		dbx.Close()
+		return nil, err
+	}
+
+	return &SqlServerMoviesStore{
+		db:       dbx,
+		dbx:      tracedExecutor{dbx},
+		fullText: fullText,
+	}, nil
 }
 
-func (s *SqlServerMoviesStore) close() error {
-	return s.dbx.Close()
+func (s *SqlServerMoviesStore) Close() error {
+	return s.db.Close()
 }
 
-func (s *SqlServerMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
-	err := s.connect(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer s.close()
+// DB returns the connection pool of the store, e.g. to collect its stats.
+func (s *SqlServerMoviesStore) DB() *sql.DB {
+	return s.db.DB
+}
 
+func (s *SqlServerMoviesStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SqlServerMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
 	var movies []Movie
 	if err := s.dbx.SelectContext(
 		ctx,
 		&movies,
 		`SELECT
-			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt
+			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version
 		FROM Movies`); err != nil {
 		return nil, err
 	}
@@ -61,19 +108,126 @@ func (s *SqlServerMoviesStore) GetAll(ctx context.Context) ([]Movie, error) {
 	return movies, nil
 }
 
-func (s *SqlServerMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
-	err := s.connect(ctx)
+var sqlServerMovieColumns = movieColumns{
+	ID:          "Id",
+	SortID:      "LOWER(CONVERT(CHAR(36), Id)) COLLATE Latin1_General_BIN2",
+	Title:       "Title",
+	SortTitle:   "CAST(Title AS NVARCHAR(100)) COLLATE Latin1_General_BIN2",
+	Director:    "Director",
+	ReleaseDate: "ReleaseDate",
+	TicketPrice: "TicketPrice",
+	CreatedAt:   "CreatedAt",
+	UpdatedAt:   "UpdatedAt",
+	Version:     "Version",
+}
+
+func (s *SqlServerMoviesStore) List(ctx context.Context, listMoviesParams ListMoviesParams) (MoviesPage, error) {
+	where, orderBy, args, err := buildListMoviesQuery(sqlServerMovieColumns, listMoviesParams)
+	if err != nil {
+		return MoviesPage{}, err
+	}
+
+	limit := ""
+	if listMoviesParams.Limit > 0 {
+		limit = "OFFSET 0 ROWS FETCH NEXT :limit ROWS ONLY"
+		args["limit"] = listMoviesParams.Limit + 1
+	}
+
+	query, queryArgs, err := s.dbx.BindNamed(
+		`SELECT
+			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version
+		FROM Movies
+		`+where+`
+		`+orderBy+`
+		`+limit,
+		args)
 	if err != nil {
-		return Movie{}, err
+		return MoviesPage{}, err
 	}
-	defer s.close()
 
+	var movies []Movie
+	if err := s.dbx.SelectContext(ctx, &movies, query, queryArgs...); err != nil {
+		return MoviesPage{}, err
+	}
+
+	return nextPage(movies, listMoviesParams.Limit), nil
+}
+
+// Search ranks movies with CONTAINSTABLE when a full-text index is available,
+// otherwise it falls back to matching word prefixes with LIKE and weights
+// title matches twice as much as director matches.
+func (s *SqlServerMoviesStore) Search(ctx context.Context, searchMoviesParams SearchMoviesParams) ([]Movie, error) {
+	terms, err := parseSearchTerms(searchMoviesParams.Query)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	args := map[string]any{}
+	limit := ""
+	if searchMoviesParams.Limit > 0 {
+		limit = "OFFSET 0 ROWS FETCH NEXT :limit ROWS ONLY"
+		args["limit"] = searchMoviesParams.Limit
+	}
+
+	var q string
+	if s.fullText {
+		for i, term := range terms {
+			terms[i] = `"` + term + `*"`
+		}
+		args["query"] = strings.Join(terms, " AND ")
+
+		q = `SELECT
+			m.Id, m.Title, m.Director, m.ReleaseDate, m.TicketPrice, m.CreatedAt, m.UpdatedAt, m.Version
+		FROM Movies m
+		INNER JOIN CONTAINSTABLE(Movies, (Title, Director), :query) ft ON m.Id = ft.[KEY]
+		ORDER BY ft.RANK DESC, m.Title, m.CreatedAt, m.Id
+		` + limit
+	} else {
+		// terms only contain letters and digits so need no LIKE escaping
+		conditions := []string{}
+		scores := []string{}
+		for i, term := range terms {
+			prefix, word := fmt.Sprintf("prefix%d", i), fmt.Sprintf("word%d", i)
+			args[prefix] = term + "%"
+			args[word] = "% " + term + "%"
+
+			title := fmt.Sprintf("(Title LIKE :%s OR Title LIKE :%s)", prefix, word)
+			director := fmt.Sprintf("(Director LIKE :%s OR Director LIKE :%s)", prefix, word)
+			conditions = append(conditions, "("+title+" OR "+director+")")
+			scores = append(scores, fmt.Sprintf("CASE WHEN %s THEN 2 WHEN %s THEN 1 ELSE 0 END", title, director))
+		}
+
+		q = `SELECT
+			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version
+		FROM Movies
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + strings.Join(scores, " + ") + ` DESC, Title, CreatedAt, Id
+		` + limit
+	}
+
+	query, queryArgs, err := s.dbx.BindNamed(q, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var movies []Movie
+	if err := s.dbx.SelectContext(ctx, &movies, query, queryArgs...); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+func (s *SqlServerMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie, error) {
 	var movie Movie
 	if err := s.dbx.GetContext(
 		ctx,
 		&movie,
 		`SELECT
-			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt
+			Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version
 		FROM Movies
 		WHERE Id = @id`,
 		sql.Named("id", id)); err != nil {
@@ -87,13 +241,17 @@ func (s *SqlServerMoviesStore) GetByID(ctx context.Context, id uuid.UUID) (Movie
 	return movie, nil
 }
 
-func (s *SqlServerMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
-	err := s.connect(ctx)
-	if err != nil {
-		return err
-	}
-	defer s.close()
+const (
+	insertMovieQuery = `INSERT INTO Movies
+			(Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt, Version)
+		VALUES
+			(:Id, :Title, :Director, :ReleaseDate, :TicketPrice, :CreatedAt, :UpdatedAt, :Version)`
+	// movies per INSERT in CreateMany, each takes 8 of the 2100 parameters
+	// SQL Server allows in a request
+	createManyBatchSize = 250
+)
 
+func (s *SqlServerMoviesStore) Create(ctx context.Context, createMovieParams CreateMovieParams) error {
 	movie := Movie{
 		ID:          createMovieParams.ID,
 		Title:       createMovieParams.Title,
@@ -102,15 +260,10 @@ func (s *SqlServerMoviesStore) Create(ctx context.Context, createMovieParams Cre
 		TicketPrice: createMovieParams.TicketPrice,
 		CreatedAt:   time.Now().UTC(),
 		UpdatedAt:   time.Now().UTC(),
+		Version:     1,
 	}
 
-	if _, err := s.dbx.NamedExecContext(
-		ctx,
-		`INSERT INTO Movies
-			(Id, Title, Director, ReleaseDate, TicketPrice, CreatedAt, UpdatedAt)
-		VALUES
-			(:Id, :Title, :Director, :ReleaseDate, :TicketPrice, :CreatedAt, :UpdatedAt)`,
-		movie); err != nil {
+	if _, err := s.dbx.NamedExecContext(ctx, insertMovieQuery, movie); err != nil {
 		if strings.Contains(err.Error(), "Cannot insert duplicate key") {
 			return &DuplicateKeyError{ID: createMovieParams.ID}
 		}
@@ -120,13 +273,47 @@ func (s *SqlServerMoviesStore) Create(ctx context.Context, createMovieParams Cre
 	return nil
 }
 
-func (s *SqlServerMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
-	err := s.connect(ctx)
-	if err != nil {
-		return err
+func (s *SqlServerMoviesStore) CreateMany(ctx context.Context, createMoviesParams []CreateMovieParams) error {
+	if id, ok := duplicateMovieID(createMoviesParams); ok {
+		return &DuplicateKeyError{ID: id}
 	}
-	defer s.close()
 
+	now := time.Now().UTC()
+	movies := make([]Movie, 0, len(createMoviesParams))
+	for _, p := range createMoviesParams {
+		movies = append(movies, Movie{
+			ID:          p.ID,
+			Title:       p.Title,
+			Director:    p.Director,
+			ReleaseDate: p.ReleaseDate,
+			TicketPrice: p.TicketPrice,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			Version:     1,
+		})
+	}
+
+	return s.inTx(ctx, func(tx *SqlServerMoviesStore) error {
+		for start := 0; start < len(movies); start += createManyBatchSize {
+			end := start + createManyBatchSize
+			if end > len(movies) {
+				end = len(movies)
+			}
+
+			if _, err := tx.dbx.NamedExecContext(ctx, insertMovieQuery, movies[start:end]); err != nil {
+				if strings.Contains(err.Error(), "Cannot insert duplicate key") {
+					if id, ok := reportedMovieID(err.Error(), createMoviesParams); ok {
+						return &DuplicateKeyError{ID: id}
+					}
+				}
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SqlServerMoviesStore) Update(ctx context.Context, id uuid.UUID, updateMovieParams UpdateMovieParams) error {
 	movie := Movie{
 		ID:          id,
 		Title:       updateMovieParams.Title,
@@ -134,33 +321,133 @@ func (s *SqlServerMoviesStore) Update(ctx context.Context, id uuid.UUID, updateM
 		ReleaseDate: updateMovieParams.ReleaseDate,
 		TicketPrice: updateMovieParams.TicketPrice,
 		UpdatedAt:   time.Now().UTC(),
+		Version:     updateMovieParams.ExpectedVersion,
 	}
 
-	if _, err := s.dbx.NamedExecContext(
-		ctx,
-		`UPDATE Movies
-		SET Title = :Title, Director = :Director, ReleaseDate = :ReleaseDate, TicketPrice = :TicketPrice, UpdatedAt = :UpdatedAt
-		WHERE Id = :Id`,
-		movie); err != nil {
+	query := `UPDATE Movies
+		SET Title = :Title, Director = :Director, ReleaseDate = :ReleaseDate, TicketPrice = :TicketPrice, UpdatedAt = :UpdatedAt, Version = Version + 1
+		WHERE Id = :Id`
+	if updateMovieParams.ExpectedVersion > 0 {
+		query += ` AND Version = :Version`
+	}
+
+	result, err := s.dbx.NamedExecContext(ctx, query, movie)
+	if err != nil {
 		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, updateMovieParams.ExpectedVersion)
+	}
+
 	return nil
 }
 
-func (s *SqlServerMoviesStore) Delete(ctx context.Context, id uuid.UUID) error {
-	err := s.connect(ctx)
+func (s *SqlServerMoviesStore) Patch(ctx context.Context, id uuid.UUID, patchMovieParams PatchMovieParams) error {
+	set, where, args := buildPatchMovieQuery(sqlServerMovieColumns, id, patchMovieParams, time.Now().UTC())
+	query := `UPDATE Movies
+		` + set + `
+		` + where
+
+	result, err := s.dbx.NamedExecContext(ctx, query, args)
 	if err != nil {
 		return err
 	}
-	defer s.close()
 
-	if _, err := s.dbx.ExecContext(
-		ctx,
-		`DELETE FROM Movies
-		WHERE id = @id`, sql.Named("id", id)); err != nil {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, patchMovieParams.ExpectedVersion)
+	}
+
+	return nil
+}
+
+func (s *SqlServerMoviesStore) Delete(ctx context.Context, id uuid.UUID, deleteMovieParams DeleteMovieParams) error {
+	query := `DELETE FROM Movies
+		WHERE Id = @id`
+	args := []any{sql.Named("id", id)}
+	if deleteMovieParams.ExpectedVersion > 0 {
+		query += ` AND Version = @version`
+		args = append(args, sql.Named("version", deleteMovieParams.ExpectedVersion))
+	}
+
+	result, err := s.dbx.ExecContext(ctx, query, args...)
+	if err != nil {
 		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return s.noRowsAffectedError(ctx, id, deleteMovieParams.ExpectedVersion)
+	}
+
 	return nil
 }
+
+func (s *SqlServerMoviesStore) Batch(ctx context.Context, operations []BatchOperation, atomic bool) ([]error, error) {
+	return batch(ctx, s, operations, atomic)
+}
+
+func (s *SqlServerMoviesStore) WithTx(ctx context.Context, fn func(tx Interface) error) error {
+	return s.inTx(ctx, func(tx *SqlServerMoviesStore) error {
+		return fn(tx)
+	})
+}
+
+// inTx runs fn with a copy of the store bound to a transaction, which is
+// committed if fn succeeds. A store already bound to one reuses it.
+func (s *SqlServerMoviesStore) inTx(ctx context.Context, fn func(tx *SqlServerMoviesStore) error) error {
+	if boundToTx(s.dbx) {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&SqlServerMoviesStore{db: s.db, dbx: tracedExecutor{tx}, fullText: s.fullText}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// noRowsAffectedError tells apart a missing movie from a version mismatch
+// when a conditional write did not affect any rows.
+func (s *SqlServerMoviesStore) noRowsAffectedError(ctx context.Context, id uuid.UUID, expectedVersion int64) error {
+	if expectedVersion == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	var count int
+	if err := s.dbx.GetContext(
+		ctx,
+		&count,
+		`SELECT COUNT(*) FROM Movies
+		WHERE Id = @id`,
+		sql.Named("id", id)); err != nil {
+		return err
+	}
+	if count == 0 {
+		return &RecordNotFoundError{}
+	}
+
+	return &VersionMismatchError{ID: id, ExpectedVersion: expectedVersion}
+}